@@ -1,2 +1,2 @@
 #!/bin/bash
-GOOS=js GOARCH=wasm go build -ldflags="-s -w" -o main.wasm main.go
+GOOS=js GOARCH=wasm go build -buildvcs=false -ldflags="-s -w" -o main.wasm .
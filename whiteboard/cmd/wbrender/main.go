@@ -0,0 +1,402 @@
+// Command wbrender decodes a whiteboard share link and writes the board as a
+// PNG, an SVG, a PDF, a time-lapse GIF or a JSON dump of its command list, without a browser.
+//
+// Usage:
+//
+//	wbrender [flags] <share URL | img payload | ->
+//
+// The argument is a full share URL (the img value is taken from the query or
+// the #fragment, a secret link key from #k=), a bare base64 img value, or "-"
+// to read either from standard input. Decoding, decryption, signature and
+// expiry checks are the same as in the browser.
+package main
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/raydac/bkbin2wav/codec"
+	"github.com/raydac/bkbin2wav/render"
+)
+
+// Default canvas size of the page, used when neither the payload nor the URL
+// carries one.
+const defaultWidth, defaultHeight = 640, 480
+
+// Canvas sizes the page accepts, as in its resizeCanvas.
+const minCanvasSize, maxCanvasSize = 64, 2048
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run is main with its arguments and standard streams passed in. It returns
+// the exit status: 0 on success, 1 when the board cannot be read or written
+// and 2 for bad usage.
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	warn := func(format string, args ...interface{}) {
+		fmt.Fprintf(stderr, "wbrender: "+format+"\n", args...)
+	}
+	fs := flag.NewFlagSet("wbrender", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var (
+		out      = fs.String("o", "", "output file (default standard output)")
+		format   = fs.String("format", "", "png, svg, pdf, gif or json (default from the -o extension, else png)")
+		password = fs.String("password", "", "password of a password protected link (default $WHITEBOARD_PASSWORD)")
+		linkKey  = fs.String("key", "", "secret link key, when the URL does not carry #k=")
+		identity = fs.String("identity", "", "file holding your private key as backed up from the Keys dialog")
+		delay    = fs.Int("delay", 100, "gif: frame delay in milliseconds")
+		frames   = fs.Int("frames", 300, "gif: maximum number of frames")
+		points   = fs.Int("points", 0, "gif: draw strokes this many points per frame (0 = whole strokes)")
+		speed    = fs.Float64("speed", 0, "gif: follow the recorded drawing pace at this multiple instead of -points (0 = off)")
+	)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: wbrender [flags] <share URL | img payload | ->\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+
+	f, err := outputFormat(*format, *out)
+	if err != nil {
+		warn("%v", err)
+		return 2
+	}
+	if *speed != 0 && !(*speed >= render.MinSpeed && *speed <= render.MaxSpeed) {
+		warn("-speed must be 0 or between %g and %g", render.MinSpeed, render.MaxSpeed)
+		return 2
+	}
+
+	link, err := readLink(fs.Arg(0), stdin)
+	if err != nil {
+		warn("%v", err)
+		return 1
+	}
+	if *linkKey != "" {
+		link.key = *linkKey
+	}
+	keys := codec.Keys{Password: *password}
+	if keys.Password == "" {
+		keys.Password = os.Getenv("WHITEBOARD_PASSWORD")
+	}
+	if link.key != "" {
+		if keys.LinkKey, err = decodeBase64(link.key); err != nil {
+			warn("bad secret link key: %v", err)
+			return 1
+		}
+	}
+	if *identity != "" {
+		if keys.PrivateKey, err = readIdentity(*identity); err != nil {
+			warn("%v", err)
+			return 1
+		}
+	}
+
+	board, err := openBoard(link, keys)
+	if err != nil {
+		warn("%v", err)
+		return 1
+	}
+	if sig := board.Header.Signature; sig != nil {
+		if sig.Valid {
+			warn("signed by %s", sig.Fingerprint())
+		} else {
+			warn("warning: signature mismatch, the board was changed after %s signed it", sig.Fingerprint())
+		}
+	}
+
+	// Render in memory, so a failed run leaves no truncated output behind.
+	var w bytes.Buffer
+	switch f {
+	case "png":
+		err = png.Encode(&w, board.raster())
+	case "svg":
+		if board.Legacy != nil {
+			err = errors.New("legacy bitmap links can only be written as PNG")
+			break
+		}
+		err = render.SVG(&w, board.width, board.height, board.Header.Bg, board.drawn())
+	case "pdf":
+		if board.Legacy != nil {
+			err = errors.New("legacy bitmap links can only be written as PNG")
+			break
+		}
+		err = render.PDF(&w, board.width, board.height, board.Header.Bg, board.drawn())
+	case "gif":
+		if board.Legacy != nil {
+			err = errors.New("legacy bitmap links can only be written as PNG")
+			break
+		}
+		err = render.Timelapse(&w, board.width, board.height, board.Header.Bg, board.drawn(),
+			render.TimelapseOptions{Delay: (*delay + 5) / 10, MaxFrames: *frames, PointsPerFrame: *points, Speed: *speed})
+	case "json":
+		if board.Legacy != nil {
+			err = errors.New("legacy bitmap links can only be written as PNG")
+			break
+		}
+		err = writeJSON(&w, board)
+	}
+	if err == nil {
+		if *out != "" {
+			err = os.WriteFile(*out, w.Bytes(), 0o666)
+		} else {
+			_, err = w.WriteTo(stdout)
+		}
+	}
+	if err != nil {
+		warn("%v", err)
+		return 1
+	}
+	return 0
+}
+
+// outputFormat picks the output format from the -format flag, else from the
+// extension of the -o file, else PNG.
+func outputFormat(format, out string) (string, error) {
+	f := strings.ToLower(format)
+	if f == "" {
+		f = strings.TrimPrefix(strings.ToLower(filepath.Ext(out)), ".")
+	}
+	if f == "" {
+		f = "png"
+	}
+	if f != "png" && f != "svg" && f != "pdf" && f != "gif" && f != "json" {
+		return "", fmt.Errorf("unknown format %q", f)
+	}
+	return f, nil
+}
+
+// shareLink is the part of a share URL wbrender needs.
+type shareLink struct {
+	img           string // base64 payload
+	key           string // secret link key from #k=
+	width, height int    // w and h query parameters; 0 when absent
+}
+
+// readLink parses the command-line argument (or stdin for "-").
+func readLink(arg string, stdin io.Reader) (shareLink, error) {
+	if arg == "-" {
+		data, err := io.ReadAll(stdin)
+		if err != nil {
+			return shareLink{}, err
+		}
+		arg = string(data)
+	}
+	arg = strings.TrimSpace(arg)
+	if !strings.ContainsAny(arg, "?#") {
+		return shareLink{img: arg}, nil
+	}
+
+	u, err := url.Parse(arg)
+	if err != nil {
+		return shareLink{}, err
+	}
+	query := u.Query()
+	fragment, err := url.ParseQuery(u.Fragment)
+	if err != nil {
+		return shareLink{}, fmt.Errorf("bad URL fragment: %v", err)
+	}
+	link := shareLink{img: query.Get("img"), key: fragment.Get("k")}
+	if link.img == "" {
+		link.img = fragment.Get("img")
+	}
+	if link.img == "" {
+		return shareLink{}, errors.New("no img parameter in the URL")
+	}
+	link.width, _ = strconv.Atoi(query.Get("w"))
+	link.height, _ = strconv.Atoi(query.Get("h"))
+	return link, nil
+}
+
+// decodeBase64 accepts URL-safe base64 without padding, as the page writes it,
+// and standard base64 from older links.
+func decodeBase64(s string) ([]byte, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		data, err = base64.StdEncoding.DecodeString(s)
+	}
+	return data, err
+}
+
+// readIdentity reads a base64url X25519 private key from file.
+func readIdentity(file string) (*ecdh.PrivateKey, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, fmt.Errorf("%s: not a private key: %v", file, err)
+	}
+	priv, err := codec.ParsePrivateKey(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%s: not a private key: %v", file, err)
+	}
+	return priv, nil
+}
+
+// board is a decoded share link ready to be written out.
+type board struct {
+	*codec.Image
+	width, height int
+	policy        codec.Policy
+}
+
+// openBoard decrypts and decodes link like the page does, refusing expired
+// links.
+func openBoard(link shareLink, keys codec.Keys) (*board, error) {
+	data, err := decodeBase64(link.img)
+	if err != nil {
+		return nil, fmt.Errorf("img is not valid base64: %v", err)
+	}
+	plain, policy, err := keys.Unseal(data)
+	switch {
+	case errors.Is(err, codec.ErrNeedsPassword):
+		return nil, errors.New("the link is password protected; pass -password")
+	case errors.Is(err, codec.ErrNeedsKey):
+		return nil, errors.New("the link is a secret link without its #k= key; pass -key")
+	case errors.Is(err, codec.ErrRecipientsOnly) && keys.PrivateKey == nil:
+		return nil, errors.New("the link is encrypted for recipients; pass -identity")
+	case err != nil:
+		return nil, err
+	}
+	img, err := codec.Load(plain)
+	if err != nil {
+		return nil, err
+	}
+	policy = policy.Merge(img.Header.Policy)
+	if err := policy.Check(time.Now()); err != nil {
+		return nil, err
+	}
+
+	b := &board{Image: img, width: img.Header.Width, height: img.Header.Height, policy: policy}
+	if b.width == 0 || b.height == 0 {
+		// Like the page, ignore a w or h it would not resize to.
+		b.width, b.height = defaultWidth, defaultHeight
+		if canvasSizeOK(link.width) {
+			b.width = link.width
+		}
+		if canvasSizeOK(link.height) {
+			b.height = link.height
+		}
+	} else if !canvasSizeOK(b.width) || !canvasSizeOK(b.height) {
+		return nil, fmt.Errorf("unsupported canvas size %dx%d", b.width, b.height)
+	}
+	return b, nil
+}
+
+// canvasSizeOK reports whether the page accepts n as a canvas width or height.
+func canvasSizeOK(n int) bool {
+	return n >= minCanvasSize && n <= maxCanvasSize
+}
+
+// raster renders the board at its canvas size.
+func (b *board) raster() *image.RGBA {
+	dst := image.NewRGBA(image.Rect(0, 0, b.width, b.height))
+	if b.Legacy != nil {
+		render.Fill(dst, codec.White)
+		draw.Draw(dst, b.Legacy.Bounds(), b.Legacy, b.Legacy.Bounds().Min, draw.Src)
+		return dst
+	}
+	render.Replay(dst, b.Header.Bg, b.drawn())
+	return dst
+}
+
+// drawn returns the commands the board shows: all but the undone ones.
+func (b *board) drawn() []codec.Cmd {
+	return b.Cmds[:len(b.Cmds)-b.Header.Undone]
+}
+
+// jsonCmd is one command in the JSON dump.
+type jsonCmd struct {
+	Type   string   `json:"type"` // "stroke", "clear" or "fill"
+	Color  string   `json:"color,omitempty"`
+	Width  int      `json:"width,omitempty"`
+	Points [][2]int `json:"points,omitempty"`
+	Times  []int64  `json:"times,omitempty"`  // ms, relative to the first timed stroke
+	Joined bool     `json:"joined,omitempty"` // undone together with the command before
+}
+
+// jsonBranch is an alternative line of history in the JSON dump.
+type jsonBranch struct {
+	Fork     int       `json:"fork"` // commands shared with the main list
+	Commands []jsonCmd `json:"commands"`
+}
+
+// jsonCmds converts cmds for the JSON dump.
+func jsonCmds(cmds []codec.Cmd) []jsonCmd {
+	out := []jsonCmd{}
+	for _, cmd := range cmds {
+		switch c := cmd.(type) {
+		case *codec.Stroke:
+			out = append(out, jsonCmd{Type: "stroke",
+				Color: render.HexColor(color.RGBA{c.R, c.G, c.B, 255}), Width: int(c.Width), Points: c.Pts, Times: c.Times,
+				Joined: c.Joined})
+		case codec.Clear:
+			out = append(out, jsonCmd{Type: "clear"})
+		case codec.Fill:
+			out = append(out, jsonCmd{Type: "fill",
+				Color: render.HexColor(color.RGBA{c.R, c.G, c.B, 255})})
+		}
+	}
+	return out
+}
+
+// writeJSON dumps the header and command list of b.
+func writeJSON(w io.Writer, b *board) error {
+	doc := struct {
+		Version    int          `json:"version"`
+		Width      int          `json:"width"`
+		Height     int          `json:"height"`
+		Background string       `json:"background"`
+		ReadOnly   bool         `json:"readOnly,omitempty"`
+		Expires    string       `json:"expires,omitempty"`
+		Signer     string       `json:"signer,omitempty"`
+		Signed     *bool        `json:"signatureValid,omitempty"`
+		Commands   []jsonCmd    `json:"commands"`
+		Undone     int          `json:"undone,omitempty"` // trailing commands not drawn
+		Branches   []jsonBranch `json:"branches,omitempty"`
+	}{
+		Version:    int(b.Header.Version),
+		Width:      b.width,
+		Height:     b.height,
+		Background: render.HexColor(b.Header.Bg),
+		ReadOnly:   b.policy.ReadOnly,
+		Commands:   jsonCmds(b.Cmds),
+		Undone:     b.Header.Undone,
+	}
+	if !b.policy.Expires.IsZero() {
+		doc.Expires = b.policy.Expires.UTC().Format(time.RFC3339)
+	}
+	if sig := b.Header.Signature; sig != nil {
+		doc.Signer = sig.Fingerprint()
+		doc.Signed = &sig.Valid
+	}
+	for _, br := range b.Header.Branches {
+		doc.Branches = append(doc.Branches, jsonBranch{Fork: br.Fork, Commands: jsonCmds(br.Cmds)})
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(doc)
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"image/gif"
+	"image/png"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/raydac/bkbin2wav/codec"
+)
+
+var cmds = []codec.Cmd{
+	&codec.Stroke{R: 255, Width: 4, Pts: [][2]int{{10, 20}, {300, 10}}},
+	&codec.Stroke{B: 255, Width: 2, Pts: [][2]int{{5, 5}}},
+}
+
+func encode(data []byte) string { return base64.RawURLEncoding.EncodeToString(data) }
+
+func TestReadLink(t *testing.T) {
+	tests := []struct {
+		name  string
+		arg   string
+		stdin string
+		want  shareLink
+	}{
+		{"bare payload", "  AbC-_9 \n", "", shareLink{img: "AbC-_9"}},
+		{"query", "https://example.com/wb/?img=AbC&w=800&h=600", "", shareLink{img: "AbC", width: 800, height: 600}},
+		{"fragment", "https://example.com/wb/#img=AbC&k=KeY", "", shareLink{img: "AbC", key: "KeY"}},
+		{"query and key", "https://example.com/wb/?img=AbC#k=KeY", "", shareLink{img: "AbC", key: "KeY"}},
+		{"bad size", "https://example.com/wb/?img=AbC&w=wide", "", shareLink{img: "AbC"}},
+		{"stdin", "-", "https://example.com/wb/?img=AbC&h=300\n", shareLink{img: "AbC", height: 300}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := readLink(tt.arg, strings.NewReader(tt.stdin))
+			if err != nil {
+				t.Fatalf("readLink: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("readLink = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	for _, arg := range []string{"https://example.com/wb/?w=800", "https://example.com/wb/#img=AbC&k=%zz"} {
+		if _, err := readLink(arg, nil); err == nil {
+			t.Errorf("readLink(%q) succeeded", arg)
+		}
+	}
+}
+
+func TestOpenBoard(t *testing.T) {
+	plain := codec.Encode(cmds)
+	sized := codec.EncodeWithHeader(codec.Header{Width: 800, Height: 600, Bg: codec.White}, cmds)
+	sealed, err := codec.Seal(plain, "hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := codec.NewKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, err := codec.SealWithKey(plain, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired, err := codec.Policy{Expires: time.Now().Add(-time.Hour)}.Seal(plain, "hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name          string
+		link          shareLink
+		keys          codec.Keys
+		width, height int
+	}{
+		{"header size", shareLink{img: encode(sized), width: 100, height: 100}, codec.Keys{}, 800, 600},
+		{"default size", shareLink{img: encode(plain)}, codec.Keys{}, defaultWidth, defaultHeight},
+		{"link size", shareLink{img: encode(plain), width: 1024, height: 64}, codec.Keys{}, 1024, 64},
+		{"link size out of range", shareLink{img: encode(plain), width: 4096, height: 63}, codec.Keys{}, defaultWidth, defaultHeight},
+		{"password", shareLink{img: encode(sealed)}, codec.Keys{Password: "hunter2"}, defaultWidth, defaultHeight},
+		{"secret link", shareLink{img: encode(secret)}, codec.Keys{LinkKey: key}, defaultWidth, defaultHeight},
+		{"standard base64", shareLink{img: base64.StdEncoding.EncodeToString(plain)}, codec.Keys{}, defaultWidth, defaultHeight},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := openBoard(tt.link, tt.keys)
+			if err != nil {
+				t.Fatalf("openBoard: %v", err)
+			}
+			if b.width != tt.width || b.height != tt.height {
+				t.Errorf("size = %dx%d, want %dx%d", b.width, b.height, tt.width, tt.height)
+			}
+			if !reflect.DeepEqual(b.Cmds, cmds) {
+				t.Errorf("commands = %v, want %v", b.Cmds, cmds)
+			}
+		})
+	}
+
+	errTests := []struct {
+		name string
+		link shareLink
+		keys codec.Keys
+		want string
+	}{
+		{"not base64", shareLink{img: "!!"}, codec.Keys{}, "img is not valid base64"},
+		{"no password", shareLink{img: encode(sealed)}, codec.Keys{}, "pass -password"},
+		{"no key", shareLink{img: encode(secret)}, codec.Keys{}, "pass -key"},
+		{"expired", shareLink{img: encode(expired)}, codec.Keys{Password: "hunter2"}, "expired"},
+	}
+	for _, tt := range errTests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := openBoard(tt.link, tt.keys)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("openBoard error = %v, want one mentioning %q", err, tt.want)
+			}
+		})
+	}
+	if _, err := openBoard(shareLink{img: encode(sealed)}, codec.Keys{Password: "hunter3"}); !errors.Is(err, codec.ErrAuth) {
+		t.Errorf("openBoard with the wrong password: %v, want ErrAuth", err)
+	}
+}
+
+func TestOutputFormat(t *testing.T) {
+	tests := []struct{ format, out, want string }{
+		{"", "", "png"},
+		{"", "board.SVG", "svg"},
+		{"pdf", "board.png", "pdf"},
+		{"GIF", "", "gif"},
+		{"", "dump.json", "json"},
+		{"", "board", "png"},
+	}
+	for _, tt := range tests {
+		if got, err := outputFormat(tt.format, tt.out); err != nil || got != tt.want {
+			t.Errorf("outputFormat(%q, %q) = %q, %v, want %q", tt.format, tt.out, got, err, tt.want)
+		}
+	}
+	if _, err := outputFormat("", "board.jpg"); err == nil {
+		t.Error("outputFormat accepted a .jpg output file")
+	}
+}
+
+func TestRun(t *testing.T) {
+	link := "https://example.com/wb/?img=" + encode(codec.EncodeWithHeader(
+		codec.Header{Width: 320, Height: 200, Bg: codec.White}, cmds))
+
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{link}, nil, &stdout, &stderr); code != 0 {
+		t.Fatalf("png: exit %d: %s", code, stderr.String())
+	}
+	img, err := png.Decode(&stdout)
+	if err != nil {
+		t.Fatalf("png: %v", err)
+	}
+	if got := img.Bounds().Size(); got.X != 320 || got.Y != 200 {
+		t.Errorf("png size = %v, want 320x200", got)
+	}
+
+	stdout.Reset()
+	if code := run([]string{"-format", "json", link}, nil, &stdout, &stderr); code != 0 {
+		t.Fatalf("json: exit %d: %s", code, stderr.String())
+	}
+	var doc struct {
+		Width, Height int
+		Background    string
+		Commands      []jsonCmd
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &doc); err != nil {
+		t.Fatalf("json: %v", err)
+	}
+	if doc.Width != 320 || doc.Height != 200 || doc.Background != "#ffffff" {
+		t.Errorf("json header = %dx%d %s, want 320x200 #ffffff", doc.Width, doc.Height, doc.Background)
+	}
+	want := []jsonCmd{
+		{Type: "stroke", Color: "#ff0000", Width: 4, Points: [][2]int{{10, 20}, {300, 10}}},
+		{Type: "stroke", Color: "#0000ff", Width: 2, Points: [][2]int{{5, 5}}},
+	}
+	if !reflect.DeepEqual(doc.Commands, want) {
+		t.Errorf("json commands = %+v, want %+v", doc.Commands, want)
+	}
+
+	out := filepath.Join(t.TempDir(), "board.svg")
+	stdout.Reset()
+	if code := run([]string{"-o", out, link}, nil, &stdout, &stderr); code != 0 {
+		t.Fatalf("svg: exit %d: %s", code, stderr.String())
+	}
+	svg, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(svg, []byte("<svg ")) || stdout.Len() != 0 {
+		t.Errorf("-o board.svg wrote %d bytes to the file starting %.20q and %d to stdout", len(svg), svg, stdout.Len())
+	}
+
+	stdout.Reset()
+	if code := run([]string{"-format", "gif", "-speed", "64", link}, nil, &stdout, &stderr); code != 0 {
+		t.Fatalf("gif: exit %d: %s", code, stderr.String())
+	}
+	if _, err := gif.DecodeAll(&stdout); err != nil {
+		t.Errorf("gif: %v", err)
+	}
+
+	for _, args := range [][]string{{"-format", "jpg", link}, {}, {link, link}, {"-frames", "many", link},
+		{"-format", "gif", "-speed", "1e-300", link}, {"-speed", "-1", link}, {"-speed", "NaN", link}} {
+		stderr.Reset()
+		if code := run(args, nil, &stdout, &stderr); code != 2 {
+			t.Errorf("run(%q) = %d, want 2", args, code)
+		}
+	}
+	if code := run([]string{"not a board"}, nil, &stdout, &stderr); code != 1 {
+		t.Errorf("run of a bad payload = %d, want 1", code)
+	}
+
+	// A run that fails to render leaves the output file as it was.
+	var legacy bytes.Buffer
+	binary.Write(&legacy, binary.LittleEndian, [4]uint16{0, 0, 2, 2})
+	fw, _ := flate.NewWriter(&legacy, flate.BestCompression)
+	fw.Write([]byte{0xE0})
+	fw.Close()
+	kept := filepath.Join(t.TempDir(), "kept.svg")
+	if err := os.WriteFile(kept, []byte("old"), 0o666); err != nil {
+		t.Fatal(err)
+	}
+	stderr.Reset()
+	if code := run([]string{"-o", kept, encode(legacy.Bytes())}, nil, &stdout, &stderr); code != 1 ||
+		!strings.Contains(stderr.String(), "only be written as PNG") {
+		t.Errorf("svg of a legacy board: exit %d: %s", code, stderr.String())
+	}
+	if got, err := os.ReadFile(kept); err != nil || string(got) != "old" {
+		t.Errorf("output file after a failed run = %q, %v, want %q", got, err, "old")
+	}
+}
@@ -0,0 +1,143 @@
+// Package codec implements the whiteboard share format: the vector command log,
+// its FLATE-compressed binary encoding, the legacy bitmap format and the
+// AES-GCM envelope for password protected and secret-key links. It has no
+// browser dependencies, so the wasm front end and native Go tools decode share
+// links the same way.
+package codec
+
+import "image/color"
+
+// ── Vector format constants ──────────────────────────────────────────────────
+// Wire format v3 (uncompressed payload, then FLATE level-9 compressed):
+//   Header     : magic 'V' (1) | version 0x03 (1) | hdrLen uvarint
+//              | header fields (hdrLen bytes) | cmdCount uvarint
+//   Header field: tag(1) | len uvarint | value(len); unknown tags are skipped
+//   HDR_CANVAS (0x01): width uvarint | height uvarint | background R G B (3)
+//   HDR_POLICY (0x02): expiry and read-only flag; see policy.go
+//   HDR_UNDONE (0x03): count uvarint - the last count commands are undone:
+//                    not drawn, but can be redone. Decoders that skip this
+//                    field draw them.
+//   CMD_STROKE (0x01): tag(1) | R G B W(4) | pointCount uvarint
+//                    | x0 varint | y0 varint      (first point, absolute)
+//                    | dx varint | dy varint      (repeated pointCount-1)
+//   CMD_STROKE_T (0x04): CMD_STROKE with tag 0x04, then capture times:
+//                    | gap uvarint  (ms from the last point of the previous
+//                                    timed stroke; 0 for the first one)
+//                    | dt uvarint   (ms since the previous point, repeated
+//                                    pointCount-1)
+//   CMD_CLEAR  (0x02): tag(1)        - no payload
+//   CMD_FILL   (0x03): tag(1) | R G B (3)
+//   Trailer    : optional fields after the last command, laid out like header
+//                fields; unknown tags are skipped, and decoders before the
+//                trailer ignore it altogether
+//   TRL_BRANCHES (0x02): branchCount uvarint, then per branch:
+//                fork uvarint (commands of the list above it starts after)
+//                | cmdCount uvarint | commands as in the command list
+//                (CMD_STROKE_T gaps run on from the list and earlier branches)
+//   TRL_GROUPS (0x03): joinedCount uvarint, then per joined stroke:
+//                skip uvarint (commands since the previous joined stroke, or
+//                since the start; counted through the command list and then
+//                the commands of each branch, as decoded)
+//   TRL_SIGNATURE (0x01): Ed25519 public key (32) | signature (64) over every
+//                payload byte before this field; see sign.go. Written last, and
+//                a payload with bytes after it is rejected.
+// uvarint/varint are the encoding/binary forms (varint is zig-zag signed), so
+// neither the command count nor the point count of a stroke can wrap around.
+//
+// Wire format v2 (read-only): as v3 without the hdrLen and header fields.
+//
+// Wire format v1 (read-only):
+//   Header     : magic 'V' (1) | version 0x01 (1) | cmdCount uint16LE
+//   CMD_STROKE (0x01): tag(1) | R G B W(4) | pointCount uint16LE
+//                    | x0 uint16LE | y0 uint16LE  (first point, absolute)
+//                    | dx, dy as readDelta        (repeated pointCount-1)
+//   CMD_CLEAR / CMD_FILL as in v3.
+//
+// Share URLs carry base64url(FLATE_payload), or base64url(envelope) when
+// password protected; see crypto.go for the envelope. Secret links put the
+// envelope and its base64url key in the fragment: #img=...&k=...
+// Saved PNG files carry the plain FLATE payload in a wbVC chunk; see png.go.
+
+const (
+	vecMagic       = byte('V')
+	vecVersion     = byte(0x03) // version written by Encode
+	vecVersion2    = byte(0x02) // no header fields; decode only
+	vecVersion1    = byte(0x01) // uint16 counts; decode only
+	vecHdrCanvas   = byte(0x01)
+	vecHdrPolicy   = byte(0x02)
+	vecHdrUndone   = byte(0x03)
+	vecTagStroke   = byte(0x01)
+	vecTagClear    = byte(0x02)
+	vecTagFill     = byte(0x03)
+	vecTagStrokeT  = byte(0x04)
+	vecTrlSig      = byte(0x01)
+	vecTrlBranches = byte(0x02)
+	vecTrlGroups   = byte(0x03)
+
+	// EncMagic is the first byte of an encrypted share payload.
+	EncMagic = byte('E') // 0x45
+
+	// MinCanvasSize and MaxCanvasSize bound each side of the canvas a header
+	// may ask for, whatever the Limits. The whiteboard never edits a board
+	// below the minimum; Limits usually cap the maximum much lower.
+	MinCanvasSize = 64
+	MaxCanvasSize = 1 << 16
+)
+
+// Cmd is one entry of the command log: *Stroke, Clear or Fill.
+type Cmd interface{ isCmd() }
+
+// Stroke is a freehand or straight line drawn with a round pen.
+type Stroke struct {
+	R, G, B byte
+	Width   byte
+	Pts     [][2]int // absolute canvas coordinates, in drawing order
+
+	// Times holds the capture time of each point in milliseconds, nil when
+	// the stroke was not timed. Only differences matter: decoded times count
+	// from the first timed stroke of the payload. When set it has one
+	// non-decreasing entry per point and is encoded as CMD_STROKE_T.
+	Times []int64
+
+	// Joined marks a stroke that is undone and redone together with the
+	// command before it, such as a freehand line resumed after leaving the
+	// canvas. It travels in TRL_GROUPS.
+	Joined bool
+}
+
+// Timed reports whether s carries a capture time for every point.
+func (s *Stroke) Timed() bool { return len(s.Times) > 0 && len(s.Times) == len(s.Pts) }
+
+func (s *Stroke) isCmd() {}
+
+// Clear resets the whole canvas to the background colour.
+type Clear struct{}
+
+func (Clear) isCmd() {}
+
+// Fill paints the whole canvas with a solid colour.
+type Fill struct{ R, G, B byte }
+
+func (Fill) isCmd() {}
+
+// Header is the board-level metadata carried in front of the command log.
+type Header struct {
+	Version       byte       // wire format version the payload was written in
+	Width, Height int        // canvas size; 0 when the payload does not carry it
+	Bg            color.RGBA // background colour Clear restores
+	Signature     *Signature // author signature from the trailer; nil if unsigned
+	Policy        Policy     // link restrictions; authentic only when signed
+	Undone        int        // trailing commands that are undone, the redo stack
+	Branches      []Branch   // alternative histories from the trailer
+}
+
+// Branch is an alternative line of history, such as one abandoned by drawing
+// after an undo: the first Fork commands of the payload's command list
+// followed by Cmds.
+type Branch struct {
+	Fork int
+	Cmds []Cmd
+}
+
+// White is the default board background.
+var White = color.RGBA{255, 255, 255, 255}
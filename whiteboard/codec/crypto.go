@@ -0,0 +1,377 @@
+package codec
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+)
+
+// ── Encryption envelope ──────────────────────────────────────────────────────
+// Envelope v2 (written by Seal):
+//   'E' (1) | envVersion 0x02 (1) | hdrLen uvarint | header fields (hdrLen bytes)
+//   | nonce (12) | AES-256-GCM ciphertext || tag
+//   Header field: tag(1) | len uvarint | value(len); every byte from 'E' up to
+//   the nonce is bound to the ciphertext as GCM additional data.
+//   ENV_PBKDF2 (0x01): iterations uvarint | salt (16)
+//                      key = PBKDF2-HMAC-SHA256(password, salt, iterations, 32)
+//   ENV_RAWKEY (0x02): empty; the 32-byte key is random and travels out of band
+//                      (the #k= fragment of a secret link)
+//   ENV_RECIPIENTS (0x03): X25519 key wrapping for listed recipients; see
+//                      recipients.go
+//   ENV_POLICY (0x04): expiry and read-only flag; see policy.go
+//   Exactly one key field is present.
+//
+// Legacy envelopes (read-only, tried when the v2 parse or authentication fails):
+//   'E' | nonce (12) | AES-256-GCM(ciphertext)   key = SHA-256(password)
+//   "ENC:" | nonce (12) | AES-256-GCM(ciphertext) key = SHA-256(password)
+
+const (
+	envVersion   = byte(0x02)
+	envHdrPBKDF2 = byte(0x01)
+	envHdrRawKey = byte(0x02)
+	envHdrRecips = byte(0x03)
+	envHdrPolicy = byte(0x04)
+	kdfSaltSize  = 16
+
+	// KeySize is the length of a secret-link key from NewKey.
+	KeySize = 32
+
+	// KDFIterations is the PBKDF2 work factor Seal writes (OWASP guidance for
+	// PBKDF2-HMAC-SHA256).
+	KDFIterations = 600000
+	// MaxKDFIterations caps the work factor Open accepts, so a crafted link
+	// cannot hang the tab in key derivation.
+	MaxKDFIterations = 10000000
+)
+
+var errEnvelope = errors.New("codec: malformed encryption envelope")
+
+// IsEncrypted reports whether a share payload is password protected, either in
+// an EncMagic envelope or behind the legacy "ENC:" string prefix. Only the
+// marker and version bytes are read: a v2 envelope starts with EncMagic and
+// envVersion, a legacy one with EncMagic and room for a nonce and GCM tag.
+// Like the first page that wrote links, it cannot tell a legacy envelope from
+// a plain payload that happens to start with EncMagic.
+func IsEncrypted(data []byte) bool {
+	if len(data) >= 4 && string(data[:4]) == "ENC:" {
+		return true
+	}
+	if len(data) < 2 || data[0] != EncMagic {
+		return false
+	}
+	return data[1] == envVersion || len(data) >= 1+12+16 // nonce and GCM tag
+}
+
+// Seal encrypts a compressed payload with password into a v2 envelope, using
+// a fresh random salt and nonce.
+func Seal(payload []byte, password string) ([]byte, error) {
+	return Policy{}.Seal(payload, password)
+}
+
+// Seal is like the package-level Seal and binds p into the envelope header.
+func (p Policy) Seal(payload []byte, password string) ([]byte, error) {
+	salt := make([]byte, kdfSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	key, err := pbkdf2.Key(sha256.New, password, salt, KDFIterations, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	var field bytes.Buffer
+	writeUvarint(&field, KDFIterations)
+	field.Write(salt)
+	var hdr bytes.Buffer
+	writeEnvField(&hdr, envHdrPBKDF2, field.Bytes())
+	p.writeField(&hdr, envHdrPolicy)
+	return sealEnvelope(key, hdr.Bytes(), payload)
+}
+
+// NewKey returns a random key for SealWithKey.
+func NewKey() ([]byte, error) {
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// SealWithKey encrypts a compressed payload under a KeySize-byte key into a v2
+// envelope. Nothing in the envelope reveals the key, so the link is only as
+// private as the channel the key is handed over on.
+func SealWithKey(payload, key []byte) ([]byte, error) {
+	return Policy{}.SealWithKey(payload, key)
+}
+
+// SealWithKey is like the package-level SealWithKey and binds p into the
+// envelope header.
+func (p Policy) SealWithKey(payload, key []byte) ([]byte, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("codec: key is %d bytes, want %d", len(key), KeySize)
+	}
+	var hdr bytes.Buffer
+	writeEnvField(&hdr, envHdrRawKey, nil)
+	p.writeField(&hdr, envHdrPolicy)
+	return sealEnvelope(key, hdr.Bytes(), payload)
+}
+
+// NeedsKey reports whether data is an envelope written by SealWithKey, which
+// OpenWithKey opens and no password does.
+func NeedsKey(data []byte) bool {
+	env, err := parseEnvelope(data)
+	return err == nil && env.rawKey
+}
+
+// Open strips the encryption marker and decrypts the rest with password. It
+// accepts v2 envelopes and both legacy forms, and returns ErrAuth when the
+// password is wrong or the data was altered, ErrNeedsKey for a secret-link
+// envelope and ErrRecipientsOnly for one sealed to public keys.
+func Open(data []byte, password string) ([]byte, error) {
+	if len(data) >= 4 && string(data[:4]) == "ENC:" {
+		return decryptLegacy(data[4:], password)
+	}
+	if len(data) < 1 || data[0] != EncMagic {
+		return nil, ErrNotEncrypted
+	}
+	env, err := parseEnvelope(data)
+	if err == nil {
+		var plain []byte
+		switch {
+		case env.rawKey:
+			err = ErrNeedsKey
+		case env.recipients != nil:
+			err = ErrRecipientsOnly
+		case env.iter > MaxKDFIterations:
+			return nil, &LimitError{What: "KDF iterations", Limit: MaxKDFIterations}
+		default:
+			var key []byte
+			key, err = pbkdf2.Key(sha256.New, password, env.salt, env.iter, 32)
+			if err != nil {
+				return nil, err
+			}
+			plain, err = openGCM(key, env.ad, env.sealed)
+		}
+		if err == nil {
+			return plain, nil
+		}
+	}
+	// A legacy nonce starts with 0x02 one time in 256; fall through.
+	plain, legacyErr := decryptLegacy(data[1:], password)
+	if legacyErr == nil || (!errors.Is(err, ErrNeedsKey) && !errors.Is(err, ErrRecipientsOnly)) {
+		return plain, legacyErr
+	}
+	return nil, err
+}
+
+// OpenWithKey decrypts an envelope written by SealWithKey. It returns ErrAuth
+// when the key is wrong or the data was altered.
+func OpenWithKey(data, key []byte) ([]byte, error) {
+	if len(data) < 1 || data[0] != EncMagic {
+		return nil, ErrNotEncrypted
+	}
+	env, err := parseEnvelope(data)
+	if err != nil {
+		return nil, err
+	}
+	if !env.rawKey || len(key) != KeySize {
+		return nil, ErrAuth
+	}
+	return openGCM(key, env.ad, env.sealed)
+}
+
+// Keys holds the secrets at hand for opening a share payload; any of them may
+// be missing.
+type Keys struct {
+	Password   string           // for password protected payloads
+	LinkKey    []byte           // the #k= key of a secret link
+	PrivateKey *ecdh.PrivateKey // for payloads sealed to public keys
+}
+
+// Unseal returns the plain payload in data together with the policy of its
+// envelope, which is authentic once the envelope opened. Data that is not
+// encrypted is returned as is with the zero Policy. When the secret the
+// payload needs is missing it returns ErrNeedsPassword, ErrNeedsKey or
+// ErrRecipientsOnly.
+func (k Keys) Unseal(data []byte) ([]byte, Policy, error) {
+	var plain []byte
+	var err error
+	switch {
+	case NeedsKey(data):
+		if k.LinkKey == nil {
+			return nil, Policy{}, ErrNeedsKey
+		}
+		plain, err = OpenWithKey(data, k.LinkKey)
+	case ForRecipients(data):
+		if k.PrivateKey == nil {
+			return nil, Policy{}, ErrRecipientsOnly
+		}
+		plain, err = OpenForRecipient(data, k.PrivateKey)
+	case IsEncrypted(data):
+		if k.Password == "" {
+			return nil, Policy{}, ErrNeedsPassword
+		}
+		plain, err = Open(data, k.Password)
+	default:
+		return data, Policy{}, nil
+	}
+	if err != nil {
+		return nil, Policy{}, err
+	}
+	return plain, EnvelopePolicy(data), nil
+}
+
+// envelope is a parsed v2 envelope header.
+type envelope struct {
+	ad         []byte       // 'E' through the header fields, bound as GCM additional data
+	sealed     []byte       // nonce || ciphertext || tag
+	rawKey     bool         // ENV_RAWKEY: the key comes from the caller
+	iter       int          // ENV_PBKDF2 work factor
+	salt       []byte       // ENV_PBKDF2 salt
+	ephemeral  []byte       // ENV_RECIPIENTS sender public key
+	recipients []wrappedKey // ENV_RECIPIENTS content key per recipient
+	policy     Policy       // ENV_POLICY
+}
+
+// parseEnvelope splits a v2 envelope into its header fields and sealed part.
+func parseEnvelope(data []byte) (*envelope, error) {
+	if len(data) < 2 || data[0] != EncMagic || data[1] != envVersion {
+		return nil, errEnvelope
+	}
+	pos := 2
+	hdrLen, k := readUvarint(data, pos)
+	if k == 0 || hdrLen > uint64(len(data)-pos-k) {
+		return nil, errEnvelope
+	}
+	pos += k
+	hdrEnd := pos + int(hdrLen)
+	env := &envelope{ad: data[:hdrEnd], sealed: data[hdrEnd:]}
+	keyFields := 0
+	for pos < hdrEnd {
+		tag := data[pos]
+		fieldLen, k := readUvarint(data[:hdrEnd], pos+1)
+		if k == 0 || fieldLen > uint64(hdrEnd-pos-1-k) {
+			return nil, errEnvelope
+		}
+		field := data[pos+1+k : pos+1+k+int(fieldLen)]
+		pos += 1 + k + int(fieldLen)
+		switch tag {
+		case envHdrPBKDF2:
+			iter, n := readUvarint(field, 0)
+			if n == 0 || iter == 0 || len(field) != n+kdfSaltSize {
+				return nil, errEnvelope
+			}
+			env.iter = int(min(iter, MaxKDFIterations+1))
+			env.salt = field[n:]
+			keyFields++
+		case envHdrRawKey:
+			if len(field) != 0 {
+				return nil, errEnvelope
+			}
+			env.rawKey = true
+			keyFields++
+		case envHdrRecips:
+			if err := env.parseRecipients(field); err != nil {
+				return nil, err
+			}
+			keyFields++
+		case envHdrPolicy:
+			var ok bool
+			if env.policy, ok = parsePolicy(field); !ok {
+				return nil, errEnvelope
+			}
+		default:
+			return nil, fmt.Errorf("codec: unknown envelope field 0x%02X", tag)
+		}
+	}
+	if keyFields != 1 {
+		return nil, errEnvelope
+	}
+	return env, nil
+}
+
+// sealEnvelope writes a v2 envelope with the given header fields and seals
+// payload under key.
+func sealEnvelope(key, hdr, payload []byte) ([]byte, error) {
+	var env bytes.Buffer
+	env.WriteByte(EncMagic)
+	env.WriteByte(envVersion)
+	writeUvarint(&env, uint64(len(hdr)))
+	env.Write(hdr)
+	return sealGCM(key, env.Bytes(), payload)
+}
+
+// writeEnvField appends one tag | len | value envelope header field.
+func writeEnvField(buf *bytes.Buffer, tag byte, value []byte) {
+	buf.WriteByte(tag)
+	writeUvarint(buf, uint64(len(value)))
+	buf.Write(value)
+}
+
+// sealGCM encrypts data under key with AES-256-GCM, authenticating ad as well,
+// and returns ad || nonce || ciphertext || auth_tag.
+func sealGCM(key, ad, data []byte) ([]byte, error) {
+	// AES-256-GCM is a NIST-approved, stable encryption algorithm
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	// GCM provides authenticated encryption (prevents tampering)
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	// Generate cryptographically secure random nonce
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	out := append(append([]byte{}, ad...), nonce...)
+	return gcm.Seal(out, nonce, data, ad), nil
+}
+
+// openGCM reverses sealGCM for data = nonce || ciphertext || auth_tag; it
+// returns ErrAuth if the key is wrong or data or ad were tampered with.
+func openGCM(key, ad, data []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	nonceSize := gcm.NonceSize()
+	if len(data) < nonceSize {
+		return nil, ErrAuth
+	}
+
+	// Extract nonce and ciphertext
+	nonce, ciphertext := data[:nonceSize], data[nonceSize:]
+
+	// Decrypt and verify authentication tag
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, ad)
+	if err != nil {
+		return nil, ErrAuth
+	}
+
+	return plaintext, nil
+}
+
+// decryptLegacy opens nonce || ciphertext || auth_tag sealed under the
+// unsalted SHA-256 of password, as written before envelope v2.
+func decryptLegacy(data []byte, password string) ([]byte, error) {
+	key := sha256.Sum256([]byte(password))
+	return openGCM(key[:], nil, data)
+}
@@ -0,0 +1,227 @@
+package codec
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Password protected links as written before envelope v2, both holding the
+// v1 fixture of decode_test.go under the password "hunter2".
+func TestOpenLegacy(t *testing.T) {
+	tests := []struct{ name, link string }{
+		{"E", "RfSP690_lICEujXIRgtUiJkgLkWLyPryWV6xRXRn277azPVnaQ0GSnkm4w3v3Jn0EvrNlcXLWL_JtuFX6xafhmXO78SGY60sfF4"},
+		{"ENC:", "RU5DOlExCp-4LKJ9YVIYzyKnsGVpeasU2qH_nvghO5Gwxt2ujemkq2tfL8UOJPfhArjXD_xwIIdjYhK8k9qIMw8qWp3LkRkLirD6CJU"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data := mustBase64(t, tt.link)
+			if !IsEncrypted(data) {
+				t.Fatal("IsEncrypted = false")
+			}
+			if _, _, err := (Keys{}).Unseal(data); err != ErrNeedsPassword {
+				t.Errorf("Unseal without a password: %v, want ErrNeedsPassword", err)
+			}
+			if _, err := Open(data, "hunter3"); err != ErrAuth {
+				t.Errorf("Open with the wrong password: %v, want ErrAuth", err)
+			}
+			plain, err := Open(data, "hunter2")
+			if err != nil {
+				t.Fatalf("Open: %v", err)
+			}
+			cmds, _, err := Decode(plain)
+			if err != nil {
+				t.Fatalf("Decode: %v", err)
+			}
+			if !reflect.DeepEqual(cmds, board) {
+				t.Errorf("commands = %v, want %v", cmds, board)
+			}
+		})
+	}
+}
+
+func TestSeal(t *testing.T) {
+	payload := Encode(board)
+	sealed, err := Seal(payload, "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsEncrypted(sealed) || NeedsKey(sealed) || ForRecipients(sealed) {
+		t.Fatal("sealed payload does not read as password protected")
+	}
+	plain, err := Open(sealed, "correct horse")
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if !bytes.Equal(plain, payload) {
+		t.Error("Open returned a different payload")
+	}
+	if _, err := Open(sealed, "battery staple"); err != ErrAuth {
+		t.Errorf("Open with the wrong password: %v, want ErrAuth", err)
+	}
+	altered := bytes.Clone(sealed)
+	altered[len(altered)-1] ^= 1
+	if _, err := Open(altered, "correct horse"); err != ErrAuth {
+		t.Errorf("Open of altered data: %v, want ErrAuth", err)
+	}
+	if _, err := Open(payload, "correct horse"); err != ErrNotEncrypted {
+		t.Errorf("Open of a plain payload: %v, want ErrNotEncrypted", err)
+	}
+}
+
+func TestIsEncrypted(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want bool
+	}{
+		{"plain", Encode(board), false},
+		{"empty", nil, false},
+		{"marker only", []byte{EncMagic}, false},
+		{"v2 envelope", []byte{EncMagic, envVersion, 0}, true},
+		{"legacy envelope", append([]byte{EncMagic}, make([]byte, 28)...), true},
+		{"too short for legacy", append([]byte{EncMagic}, make([]byte, 27)...), false},
+		{"ENC:", []byte("ENC:"), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsEncrypted(tt.data); got != tt.want {
+				t.Errorf("IsEncrypted = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSealWithKey(t *testing.T) {
+	payload := Encode(board)
+	key, err := NewKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := SealWithKey(payload, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !NeedsKey(sealed) {
+		t.Fatal("NeedsKey = false")
+	}
+	plain, err := OpenWithKey(sealed, key)
+	if err != nil {
+		t.Fatalf("OpenWithKey: %v", err)
+	}
+	if !bytes.Equal(plain, payload) {
+		t.Error("OpenWithKey returned a different payload")
+	}
+	other, _ := NewKey()
+	if _, err := OpenWithKey(sealed, other); err != ErrAuth {
+		t.Errorf("OpenWithKey with another key: %v, want ErrAuth", err)
+	}
+	if _, err := Open(sealed, "any password"); err != ErrNeedsKey {
+		t.Errorf("Open: %v, want ErrNeedsKey", err)
+	}
+	if _, _, err := (Keys{Password: "any password"}).Unseal(sealed); err != ErrNeedsKey {
+		t.Errorf("Unseal without the key: %v, want ErrNeedsKey", err)
+	}
+}
+
+func TestSealForRecipients(t *testing.T) {
+	payload := Encode(board)
+	var keys []*ecdh.PrivateKey
+	for range 3 {
+		priv, err := NewKeyPair()
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys = append(keys, priv)
+	}
+	alice, bob, eve := keys[0], keys[1], keys[2]
+	sealed, err := SealForRecipients(payload, []*ecdh.PublicKey{alice.PublicKey(), bob.PublicKey()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ForRecipients(sealed) {
+		t.Fatal("ForRecipients = false")
+	}
+	for _, priv := range []*ecdh.PrivateKey{alice, bob} {
+		plain, err := OpenForRecipient(sealed, priv)
+		if err != nil {
+			t.Fatalf("OpenForRecipient: %v", err)
+		}
+		if !bytes.Equal(plain, payload) {
+			t.Error("OpenForRecipient returned a different payload")
+		}
+	}
+	if _, err := OpenForRecipient(sealed, eve); err != ErrRecipientsOnly {
+		t.Errorf("OpenForRecipient by a non-recipient: %v, want ErrRecipientsOnly", err)
+	}
+	if _, err := Open(sealed, "any password"); err != ErrRecipientsOnly {
+		t.Errorf("Open: %v, want ErrRecipientsOnly", err)
+	}
+}
+
+// The policy is GCM additional data: changing it must break the envelope.
+func TestPolicyAuthenticated(t *testing.T) {
+	payload := Encode(board)
+	policy := Policy{Expires: time.Unix(1900000000, 0), ReadOnly: true}
+	key, _ := NewKey()
+	recipient, _ := NewKeyPair()
+	tests := []struct {
+		name string
+		seal func() ([]byte, error)
+		open func([]byte) ([]byte, error)
+	}{
+		{"password",
+			func() ([]byte, error) { return policy.Seal(payload, "pw") },
+			func(data []byte) ([]byte, error) { return Open(data, "pw") }},
+		{"key",
+			func() ([]byte, error) { return policy.SealWithKey(payload, key) },
+			func(data []byte) ([]byte, error) { return OpenWithKey(data, key) }},
+		{"recipients",
+			func() ([]byte, error) {
+				return policy.SealForRecipients(payload, []*ecdh.PublicKey{recipient.PublicKey()})
+			},
+			func(data []byte) ([]byte, error) { return OpenForRecipient(data, recipient) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sealed, err := tt.seal()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := EnvelopePolicy(sealed); !reflect.DeepEqual(got, policy) {
+				t.Errorf("EnvelopePolicy = %+v, want %+v", got, policy)
+			}
+			if _, err := tt.open(sealed); err != nil {
+				t.Fatalf("open: %v", err)
+			}
+			writable := bytes.Clone(sealed)
+			writable[policyFlags(t, writable)] &^= policyReadOnly
+			if EnvelopePolicy(writable).ReadOnly {
+				t.Fatal("the read-only flag was not cleared")
+			}
+			if _, err := tt.open(writable); !errors.Is(err, ErrAuth) {
+				t.Errorf("open with the policy changed: %v, want ErrAuth", err)
+			}
+		})
+	}
+}
+
+// policyFlags returns the offset of the flags byte of the ENV_POLICY field in
+// a v2 envelope.
+func policyFlags(t *testing.T, data []byte) int {
+	t.Helper()
+	hdrLen, k := readUvarint(data, 2)
+	pos, end := 2+k, 2+k+int(hdrLen)
+	for pos < end {
+		fieldLen, k := readUvarint(data, pos+1)
+		if data[pos] == envHdrPolicy {
+			return pos + 1 + k
+		}
+		pos += 1 + k + int(fieldLen)
+	}
+	t.Fatal("no policy field")
+	return 0
+}
@@ -0,0 +1,538 @@
+package codec
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// Image is a decoded share payload: a vector command log, or a legacy bitmap
+// for payloads that predate the vector format.
+type Image struct {
+	Header Header
+	Cmds   []Cmd
+	Legacy *LegacyBitmap // non-nil for legacy payloads; Cmds is then empty
+}
+
+// Load decodes a (decrypted) share payload of any kind within DefaultLimits.
+func Load(data []byte) (*Image, error) {
+	return DefaultLimits.Load(data)
+}
+
+// Load decodes a (decrypted) share payload of any kind: vector payloads first,
+// and anything that is not a vector FLATE stream as a legacy bitmap. When both
+// fail, the vector error is returned since it describes current links best.
+func (l Limits) Load(data []byte) (*Image, error) {
+	cmds, hdr, err := l.Decode(data)
+	if err == nil {
+		return &Image{Header: hdr, Cmds: cmds}, nil
+	}
+	var fe *FlateError
+	if !errors.As(err, &fe) && !errors.Is(err, ErrNotVector) {
+		return nil, err
+	}
+	bm, lerr := l.DecodeLegacyBitmap(data)
+	if lerr != nil {
+		return nil, err
+	}
+	return &Image{Header: Header{Bg: White}, Legacy: bm}, nil
+}
+
+// Decode decompresses and parses a vector payload within DefaultLimits.
+func Decode(data []byte) ([]Cmd, Header, error) {
+	return DefaultLimits.Decode(data)
+}
+
+// Decode decompresses and parses a vector payload as produced by Encode (any
+// supported wire version). Returns a *FlateError when data is not a FLATE
+// stream, ErrNotVector when it does not hold a vector payload and a
+// *LimitError when it needs more than l allows.
+func (l Limits) Decode(data []byte) ([]Cmd, Header, error) {
+	payload, err := inflate(data, l.MaxDecompressed)
+	if err != nil {
+		var limErr *LimitError
+		if errors.As(err, &limErr) {
+			return nil, Header{}, err
+		}
+		return nil, Header{}, &FlateError{Err: err}
+	}
+
+	if len(payload) < 2 || payload[0] != vecMagic {
+		return nil, Header{}, ErrNotVector
+	}
+	if payload[1] < vecVersion1 || payload[1] > vecVersion {
+		return nil, Header{}, &VersionError{Version: payload[1]}
+	}
+	return l.parse(payload)
+}
+
+// parse decodes the uncompressed vector payload. The v3 and v2 (varint) and
+// the v1 (uint16) layouts are accepted.
+func (l Limits) parse(payload []byte) ([]Cmd, Header, error) {
+	if len(payload) < 3 {
+		return nil, Header{}, &TruncatedError{What: "header", Offset: len(payload)}
+	}
+	hdr := Header{Version: payload[1], Bg: White}
+	v1 := hdr.Version == vecVersion1
+	pos := 2
+
+	if hdr.Version >= vecVersion {
+		hdrLen, k := readUvarint(payload, pos)
+		if k == 0 || hdrLen > uint64(len(payload)-pos-k) {
+			return nil, hdr, &TruncatedError{What: "header", Offset: pos}
+		}
+		pos += k
+		hdrEnd := pos + int(hdrLen)
+		for pos < hdrEnd {
+			tag := payload[pos]
+			fieldLen, k := readUvarint(payload[:hdrEnd], pos+1)
+			if k == 0 || fieldLen > uint64(hdrEnd-pos-1-k) {
+				return nil, hdr, &TruncatedError{What: "header", Offset: pos}
+			}
+			field := payload[pos+1+k : pos+1+k+int(fieldLen)]
+			pos += 1 + k + int(fieldLen)
+			if tag == vecHdrPolicy {
+				p, ok := parsePolicy(field)
+				if !ok {
+					return nil, hdr, &TruncatedError{What: "header", Offset: pos - len(field)}
+				}
+				hdr.Policy = p
+				continue
+			}
+			if tag == vecHdrUndone {
+				n, k := readUvarint(field, 0)
+				if k == 0 || n > uint64(len(payload)) {
+					return nil, hdr, &TruncatedError{What: "header", Offset: pos - len(field)}
+				}
+				hdr.Undone = int(n)
+				continue
+			}
+			if tag != vecHdrCanvas {
+				continue // field from a newer writer; not needed to replay
+			}
+			w, n1 := readUvarint(field, 0)
+			h, n2 := readUvarint(field, n1)
+			if n1 == 0 || n2 == 0 || n1+n2+3 > len(field) {
+				return nil, hdr, &TruncatedError{What: "header", Offset: pos - len(field)}
+			}
+			if err := checkCanvasSide("canvas width", w, l.MaxCanvasWidth); err != nil {
+				return nil, hdr, err
+			}
+			if err := checkCanvasSide("canvas height", h, l.MaxCanvasHeight); err != nil {
+				return nil, hdr, err
+			}
+			hdr.Width, hdr.Height = int(w), int(h)
+			hdr.Bg.R, hdr.Bg.G, hdr.Bg.B = field[n1+n2], field[n1+n2+1], field[n1+n2+2]
+		}
+	}
+
+	var cmdCount int
+	if v1 {
+		if len(payload) < 4 {
+			return nil, hdr, &TruncatedError{What: "header", Offset: len(payload)}
+		}
+		cmdCount = int(binary.LittleEndian.Uint16(payload[2:4]))
+		pos = 4
+	} else {
+		n, k := readUvarint(payload, pos)
+		// Every command takes at least one byte, which also keeps the
+		// conversion to int in range.
+		if k == 0 || n > uint64(len(payload)) {
+			return nil, hdr, &TruncatedError{What: "command list", Offset: pos}
+		}
+		cmdCount = int(n)
+		pos += k
+	}
+	r := cmdReader{l: l, version: hdr.Version}
+	cmds, pos, err := r.read(payload, pos, cmdCount)
+	if err != nil {
+		return nil, hdr, err
+	}
+	// Empty strokes are dropped, so the list can be shorter than counted.
+	hdr.Undone = min(hdr.Undone, len(cmds))
+	if hdr.Version >= vecVersion && pos < len(payload) {
+		if err := r.parseTrailer(payload, pos, cmds, &hdr); err != nil {
+			return nil, hdr, err
+		}
+	}
+	return cmds, hdr, nil
+}
+
+// checkCanvasSide checks one side of a header canvas against the sizes the
+// format allows and against limit (when limit > 0).
+func checkCanvasSide(what string, v uint64, limit int) error {
+	if v < MinCanvasSize || v > MaxCanvasSize {
+		return &RangeError{What: what, Value: v, Min: MinCanvasSize, Max: MaxCanvasSize}
+	}
+	if limit > 0 && v > uint64(limit) {
+		return &LimitError{What: what, Limit: limit}
+	}
+	return nil
+}
+
+// cmdReader reads command lists. One reader serves every list of a payload,
+// so the limits cover them all and CMD_STROKE_T gaps run on from one list to
+// the next.
+type cmdReader struct {
+	l       Limits
+	version byte
+	cmds    int   // commands read so far
+	pts     int   // points read so far
+	clock   int64 // end of the previous timed stroke, in its milliseconds
+}
+
+// read reads a list of n commands starting at pos and returns it with the
+// position after it.
+func (r *cmdReader) read(payload []byte, pos, n int) ([]Cmd, int, error) {
+	l := r.l
+	if l.MaxCmds > 0 && n > l.MaxCmds-r.cmds {
+		return nil, pos, &LimitError{What: "commands", Limit: l.MaxCmds}
+	}
+	r.cmds += n
+	v1 := r.version == vecVersion1
+
+	var cmds []Cmd
+	for i := 0; i < n; i++ {
+		if pos >= len(payload) {
+			return nil, pos, &TruncatedError{What: "command list", Offset: pos}
+		}
+		start := pos
+		tag := payload[pos]
+		pos++
+
+		switch tag {
+		case vecTagStroke, vecTagStrokeT:
+			if tag == vecTagStrokeT && r.version < vecVersion {
+				return nil, pos, &UnknownTagError{Tag: tag, Offset: start}
+			}
+			if pos+4 > len(payload) {
+				return nil, pos, &TruncatedError{What: "stroke", Offset: start}
+			}
+			s := &Stroke{R: payload[pos], G: payload[pos+1], B: payload[pos+2], Width: payload[pos+3]}
+			pos += 4
+			// Points this stroke may have, from whichever limit is tighter;
+			// -1 when unlimited.
+			budget, what, limit := -1, "points", l.MaxPoints
+			if l.MaxPoints > 0 {
+				budget = l.MaxPoints - r.pts
+			}
+			if l.MaxStrokePoints > 0 && (budget < 0 || l.MaxStrokePoints < budget) {
+				budget, what, limit = l.MaxStrokePoints, "points per stroke", l.MaxStrokePoints
+			}
+			var err error
+			if v1 {
+				s.Pts, pos, err = readStrokePtsV1(payload, pos, budget)
+			} else {
+				s.Pts, pos, err = readStrokePtsV2(payload, pos, budget)
+			}
+			if err == errPtBudget {
+				return nil, pos, &LimitError{What: what, Limit: limit}
+			}
+			if err == nil && tag == vecTagStrokeT {
+				s.Times, pos, err = readStrokeTimes(payload, pos, len(s.Pts), &r.clock)
+			}
+			if err != nil {
+				return nil, pos, &TruncatedError{What: "stroke", Offset: start}
+			}
+			r.pts += len(s.Pts)
+			if len(s.Pts) == 0 {
+				continue
+			}
+			cmds = append(cmds, s)
+
+		case vecTagClear:
+			cmds = append(cmds, Clear{})
+
+		case vecTagFill:
+			if pos+3 > len(payload) {
+				return nil, pos, &TruncatedError{What: "fill", Offset: start}
+			}
+			cmds = append(cmds, Fill{R: payload[pos], G: payload[pos+1], B: payload[pos+2]})
+			pos += 3
+
+		default:
+			return nil, pos, &UnknownTagError{Tag: tag, Offset: start}
+		}
+	}
+	return cmds, pos, nil
+}
+
+// parseTrailer reads the trailer fields starting at pos into hdr and cmds:
+// the branches of the command list cmds, the joined strokes of both, and the
+// signature, verified against the payload bytes in front of it. Nothing may
+// follow the signature, as it would not be covered by it.
+func (r *cmdReader) parseTrailer(payload []byte, pos int, cmds []Cmd, hdr *Header) error {
+	var joined []int
+	groupsAt := 0
+	for pos < len(payload) {
+		start := pos
+		tag := payload[pos]
+		fieldLen, k := readUvarint(payload, pos+1)
+		if k == 0 || fieldLen > uint64(len(payload)-pos-1-k) {
+			return &TruncatedError{What: "trailer", Offset: start}
+		}
+		pos += 1 + k
+		end := pos + int(fieldLen)
+		switch tag {
+		case vecTrlSig:
+			sig, err := verifySignature(payload, start, payload[pos:end])
+			if err != nil {
+				return err
+			}
+			if end != len(payload) {
+				return &TruncatedError{What: "trailer", Offset: end}
+			}
+			hdr.Signature = sig
+		case vecTrlBranches:
+			branches, err := r.readBranches(payload[:end], pos, len(cmds))
+			if err != nil {
+				return err
+			}
+			hdr.Branches = branches
+		case vecTrlGroups:
+			var err error
+			if joined, err = readGroups(payload[:end], pos); err != nil {
+				return err
+			}
+			groupsAt = start
+		}
+		// Other tags are fields from a newer writer.
+		pos = end
+	}
+
+	// The indices run through the branches, which may come in any order.
+	all := cmds
+	for _, b := range hdr.Branches {
+		all = append(all[:len(all):len(all)], b.Cmds...)
+	}
+	for _, i := range joined {
+		if i >= len(all) {
+			return &TruncatedError{What: "trailer", Offset: groupsAt}
+		}
+		s, ok := all[i].(*Stroke)
+		if !ok {
+			return &TruncatedError{What: "trailer", Offset: groupsAt}
+		}
+		s.Joined = true
+	}
+	return nil
+}
+
+// readGroups reads a TRL_GROUPS field that fills payload from pos and returns
+// the command indices it lists.
+func readGroups(payload []byte, pos int) ([]int, error) {
+	count, k := readUvarint(payload, pos)
+	// A skip takes at least one byte.
+	if k == 0 || count > uint64(len(payload)-pos) {
+		return nil, &TruncatedError{What: "trailer", Offset: pos}
+	}
+	pos += k
+	joined := make([]int, 0, count)
+	next := uint64(0)
+	for range count {
+		skip, k := readUvarint(payload, pos)
+		// Indices past the payload length cannot name a command.
+		if k == 0 || skip > uint64(len(payload)) || next+skip >= uint64(len(payload)) {
+			return nil, &TruncatedError{What: "trailer", Offset: pos}
+		}
+		next += skip
+		joined = append(joined, int(next))
+		next++
+		pos += k
+	}
+	if pos != len(payload) {
+		return nil, &TruncatedError{What: "trailer", Offset: pos}
+	}
+	return joined, nil
+}
+
+// readBranches reads a TRL_BRANCHES field that fills payload from pos, for a
+// command list of n commands.
+func (r *cmdReader) readBranches(payload []byte, pos, n int) ([]Branch, error) {
+	count, k := readUvarint(payload, pos)
+	// A branch takes at least two bytes.
+	if k == 0 || count > uint64(len(payload)-pos)/2 {
+		return nil, &TruncatedError{What: "trailer", Offset: pos}
+	}
+	pos += k
+	branches := make([]Branch, 0, count)
+	for range count {
+		fork, k1 := readUvarint(payload, pos)
+		cnt, k2 := readUvarint(payload, pos+k1)
+		if k1 == 0 || k2 == 0 || fork > uint64(n) || cnt > uint64(len(payload)) {
+			return nil, &TruncatedError{What: "trailer", Offset: pos}
+		}
+		cmds, next, err := r.read(payload, pos+k1+k2, int(cnt))
+		if err != nil {
+			return nil, err
+		}
+		branches = append(branches, Branch{Fork: int(fork), Cmds: cmds})
+		pos = next
+	}
+	if pos != len(payload) {
+		return nil, &TruncatedError{What: "trailer", Offset: pos}
+	}
+	return branches, nil
+}
+
+var (
+	errPtTruncated = errors.New("truncated stroke")
+	errPtBudget    = errors.New("point budget exceeded")
+)
+
+// readStrokePtsV1 reads a v1 stroke body (uint16 point count, uint16 absolute
+// first point, readDelta deltas) starting at pos. Returns the absolute points
+// and the position after them. Fails with errPtTruncated on truncation and
+// with errPtBudget when the stroke has more than budget points (budget < 0
+// means unlimited).
+func readStrokePtsV1(payload []byte, pos, budget int) ([][2]int, int, error) {
+	if pos+2 > len(payload) {
+		return nil, pos, errPtTruncated
+	}
+	ptCount := int(binary.LittleEndian.Uint16(payload[pos : pos+2]))
+	pos += 2
+	if ptCount == 0 {
+		return nil, pos, nil
+	}
+	if budget >= 0 && ptCount > budget {
+		return nil, pos, errPtBudget
+	}
+	if pos+4 > len(payload) {
+		return nil, pos, errPtTruncated
+	}
+	pts := make([][2]int, ptCount)
+	x := int(binary.LittleEndian.Uint16(payload[pos : pos+2]))
+	y := int(binary.LittleEndian.Uint16(payload[pos+2 : pos+4]))
+	pts[0] = [2]int{x, y}
+	pos += 4
+	for j := 1; j < ptCount; j++ {
+		dx, n := readDelta(payload, pos)
+		if n == 0 {
+			return nil, pos, errPtTruncated
+		}
+		pos += n
+		dy, n := readDelta(payload, pos)
+		if n == 0 {
+			return nil, pos, errPtTruncated
+		}
+		pos += n
+		x += dx
+		y += dy
+		pts[j] = [2]int{x, y}
+	}
+	return pts, pos, nil
+}
+
+// readStrokePtsV2 reads a v2/v3 stroke body (uvarint point count, varint
+// absolute first point, varint deltas) starting at pos. Errors as for
+// readStrokePtsV1.
+func readStrokePtsV2(payload []byte, pos, budget int) ([][2]int, int, error) {
+	cnt, n := readUvarint(payload, pos)
+	if n == 0 {
+		return nil, pos, errPtTruncated
+	}
+	pos += n
+	if budget >= 0 && cnt > uint64(budget) {
+		return nil, pos, errPtBudget
+	}
+	// Each point costs at least two bytes, so a count larger than the rest of
+	// the payload is corrupt and must not drive the allocation below.
+	if cnt > uint64(len(payload)-pos)/2 {
+		return nil, pos, errPtTruncated
+	}
+	ptCount := int(cnt)
+	if ptCount == 0 {
+		return nil, pos, nil
+	}
+	pts := make([][2]int, ptCount)
+	x, y := 0, 0
+	for j := 0; j < ptCount; j++ {
+		dx, n := readVarint(payload, pos)
+		if n == 0 {
+			return nil, pos, errPtTruncated
+		}
+		pos += n
+		dy, n := readVarint(payload, pos)
+		if n == 0 {
+			return nil, pos, errPtTruncated
+		}
+		pos += n
+		x += int(dx)
+		y += int(dy)
+		pts[j] = [2]int{x, y}
+	}
+	return pts, pos, nil
+}
+
+// readStrokeTimes reads the capture times of a CMD_STROKE_T stroke with n
+// points starting at pos, counting from *clock, which it advances to the
+// time of the last point. Fails with errPtTruncated on truncation.
+func readStrokeTimes(payload []byte, pos, n int, clock *int64) ([]int64, int, error) {
+	if n == 0 {
+		return nil, pos, nil
+	}
+	times := make([]int64, 0, n)
+	for j := 0; j < n; j++ {
+		dt, k := readUvarint(payload, pos)
+		// No drawing pauses for 2^40 ms; larger values are corrupt and
+		// must not overflow the clock.
+		if k == 0 || dt > 1<<40 {
+			return nil, pos, errPtTruncated
+		}
+		pos += k
+		*clock += int64(dt)
+		times = append(times, *clock)
+	}
+	return times, pos, nil
+}
+
+// readDelta decodes one v1 variable-length delta component from payload at pos.
+// Returns (delta value, bytes consumed). Returns (0, 0) on truncation.
+// The v1 scheme is:
+//
+//	|d| <= 126  →  1 byte: bits[6:0] = abs(d), bit7 = sign (0=positive, 1=negative)
+//	|d| >  126  →  3 bytes: 0xFF marker + int16 LE
+func readDelta(payload []byte, pos int) (int, int) {
+	if pos >= len(payload) {
+		return 0, 0
+	}
+	b := payload[pos]
+	if b == 0xFF {
+		if pos+3 > len(payload) {
+			return 0, 0
+		}
+		d := int(int16(binary.LittleEndian.Uint16(payload[pos+1 : pos+3])))
+		return d, 3
+	}
+	// 1-byte form: bit7=sign, bits[6:0]=magnitude
+	mag := int(b & 0x7F)
+	if b&0x80 != 0 {
+		mag = -mag
+	}
+	return mag, 1
+}
+
+// readUvarint decodes one unsigned varint from payload at pos.
+// Returns (value, bytes consumed). Returns (0, 0) on truncation or overflow.
+func readUvarint(payload []byte, pos int) (uint64, int) {
+	if pos >= len(payload) {
+		return 0, 0
+	}
+	v, n := binary.Uvarint(payload[pos:])
+	if n <= 0 {
+		return 0, 0
+	}
+	return v, n
+}
+
+// readVarint decodes one zig-zag signed varint from payload at pos.
+// Returns (value, bytes consumed). Returns (0, 0) on truncation or overflow.
+func readVarint(payload []byte, pos int) (int64, int) {
+	if pos >= len(payload) {
+		return 0, 0
+	}
+	v, n := binary.Varint(payload[pos:])
+	if n <= 0 {
+		return 0, 0
+	}
+	return v, n
+}
@@ -0,0 +1,274 @@
+package codec
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/base64"
+	"errors"
+	"image"
+	"image/color"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// board is the drawing the v1 and v2 fixtures below hold.
+var board = []Cmd{
+	Fill{R: 0xF0, G: 0xE0, B: 0xD0},
+	&Stroke{R: 255, Width: 4, Pts: [][2]int{{10, 20}, {15, 22}, {300, 10}, {290, 400}}},
+	Clear{},
+	&Stroke{B: 255, Width: 2, Pts: [][2]int{{5, 5}}},
+}
+
+func TestEncodeDecode(t *testing.T) {
+	expires := time.Unix(1900000000, 0)
+	tests := []struct {
+		name string
+		hdr  Header
+		cmds []Cmd
+	}{
+		{"empty", Header{}, nil},
+		{"commands", Header{}, board},
+		{"canvas", Header{Width: 800, Height: 600, Bg: color.RGBA{1, 2, 3, 255}}, board},
+		{"policy", Header{Policy: Policy{Expires: expires, ReadOnly: true}}, board},
+		{"negative and far points", Header{}, []Cmd{
+			&Stroke{Width: 1, Pts: [][2]int{{-5, -7}, {100000, 3}, {0, 70000}}},
+		}},
+		{"timed", Header{}, []Cmd{
+			&Stroke{Width: 3, Pts: [][2]int{{0, 0}, {10, 40}, {50, 2}}, Times: []int64{0, 16, 40}},
+			Clear{},
+			&Stroke{Width: 3, Pts: [][2]int{{7, 7}}, Times: []int64{940}},
+		}},
+		{"undone and branches", Header{Undone: 1, Branches: []Branch{
+			{Fork: 1, Cmds: []Cmd{&Stroke{Width: 5, Pts: [][2]int{{1, 1}, {9, 30}}}}},
+			{Fork: 0, Cmds: []Cmd{Fill{G: 9}, Clear{}}},
+		}}, board},
+		{"joined", Header{Branches: []Branch{
+			{Fork: 2, Cmds: []Cmd{
+				&Stroke{Width: 1, Pts: [][2]int{{3, 3}}},
+				&Stroke{Width: 1, Pts: [][2]int{{8, 1}}, Joined: true},
+			}},
+		}}, []Cmd{
+			&Stroke{Width: 2, Pts: [][2]int{{0, 0}, {10, 40}}},
+			&Stroke{Width: 2, Pts: [][2]int{{12, 40}, {30, 2}}, Joined: true},
+			Clear{},
+			&Stroke{Width: 2, Pts: [][2]int{{5, 5}}, Times: []int64{0}},
+			&Stroke{Width: 2, Pts: [][2]int{{6, 5}}, Times: []int64{20}, Joined: true},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmds, hdr, err := Decode(EncodeWithHeader(tt.hdr, tt.cmds))
+			if err != nil {
+				t.Fatalf("Decode: %v", err)
+			}
+			if !reflect.DeepEqual(cmds, tt.cmds) {
+				t.Errorf("commands = %v, want %v", cmds, tt.cmds)
+			}
+			want := tt.hdr
+			want.Version = vecVersion
+			if want.Width == 0 {
+				want.Bg = White
+			}
+			if !reflect.DeepEqual(hdr, want) {
+				t.Errorf("header = %+v, want %+v", hdr, want)
+			}
+		})
+	}
+}
+
+func TestEncodeSimplifies(t *testing.T) {
+	// The middle points lie on the line from the first to the last.
+	s := &Stroke{Width: 2, Pts: [][2]int{{0, 0}, {1, 1}, {2, 2}, {3, 3}, {3, 9}}, Times: []int64{0, 10, 20, 30, 45}}
+	cmds, _, err := Decode(Encode([]Cmd{s}))
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	want := &Stroke{Width: 2, Pts: [][2]int{{0, 0}, {3, 3}, {3, 9}}, Times: []int64{0, 30, 45}}
+	if !reflect.DeepEqual(cmds, []Cmd{want}) {
+		t.Errorf("commands = %v, want %v", cmds, want)
+	}
+}
+
+func TestEncodeZigZag(t *testing.T) {
+	// Every point is a corner, which once took quadratic time to find out.
+	pts := make([][2]int, 1<<16)
+	for i := range pts {
+		pts[i] = [2]int{i * 3, i % 2 * (i + 3)}
+	}
+	cmds, _, err := Decode(Encode([]Cmd{&Stroke{Width: 2, Pts: pts}}))
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if got := cmds[0].(*Stroke).Pts; !reflect.DeepEqual(got, pts) {
+		t.Errorf("decoded %d points, want all %d", len(got), len(pts))
+	}
+}
+
+// Links written by earlier versions of the whiteboard, byte for byte.
+func TestDecodeOldVersions(t *testing.T) {
+	tests := []struct {
+		name    string
+		link    string
+		version byte
+	}{
+		{"v1", "CmNkYWD-8OAC438GBhYWBi4GEQZWpv-yjD1d_9sYmRgZGP4zMTKwMrAyAAYA", vecVersion1},
+		{"v2", "CmNiYf7w4ALjfwYGFhYRDS6WXSziwj1sTIwMDP-ZGLm4AAMA", vecVersion2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img, err := Load(mustBase64(t, tt.link))
+			if err != nil {
+				t.Fatalf("Load: %v", err)
+			}
+			if img.Legacy != nil {
+				t.Fatal("loaded as a legacy bitmap")
+			}
+			if !reflect.DeepEqual(img.Cmds, board) {
+				t.Errorf("commands = %v, want %v", img.Cmds, board)
+			}
+			if want := (Header{Version: tt.version, Bg: White}); !reflect.DeepEqual(img.Header, want) {
+				t.Errorf("header = %+v, want %+v", img.Header, want)
+			}
+		})
+	}
+}
+
+func TestLoadLegacyBitmap(t *testing.T) {
+	var (
+		red     = color.RGBA{255, 0, 0, 255}
+		green   = color.RGBA{0, 255, 0, 255}
+		blue    = color.RGBA{0, 0, 255, 255}
+		white   = color.RGBA{255, 255, 255, 255}
+		black   = color.RGBA{0, 0, 0, 255}
+		yellow  = color.RGBA{255, 255, 0, 255}
+		cyan    = color.RGBA{0, 255, 255, 255}
+		magenta = color.RGBA{255, 0, 255, 255}
+	)
+	tests := []struct {
+		name   string
+		link   string
+		bounds image.Rectangle
+		pixels map[image.Point]color.RGBA
+	}{
+		{"colours", "AwAEAAQAAgDq-DgXMAA", image.Rect(3, 4, 7, 6), map[image.Point]color.RGBA{
+			{3, 4}: red, {4, 4}: green, {5, 4}: blue, {6, 4}: white,
+			{3, 5}: black, {4, 5}: yellow, {5, 5}: cyan, {6, 5}: magenta,
+		}},
+		{"run", "AAAAAAgACAD6_18CMAA", image.Rect(0, 0, 8, 8), map[image.Point]color.RGBA{
+			{0, 0}: white, {7, 0}: white, {0, 7}: white, {7, 7}: white,
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img, err := Load(mustBase64(t, tt.link))
+			if err != nil {
+				t.Fatalf("Load: %v", err)
+			}
+			if img.Legacy == nil {
+				t.Fatal("not loaded as a legacy bitmap")
+			}
+			if got := img.Legacy.Bounds(); got != tt.bounds {
+				t.Errorf("bounds = %v, want %v", got, tt.bounds)
+			}
+			for p, want := range tt.pixels {
+				if got := img.Legacy.At(p.X, p.Y); got != want {
+					t.Errorf("pixel %v = %v, want %v", p, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	strokes := Encode([]Cmd{
+		&Stroke{Width: 2, Pts: [][2]int{{0, 0}, {10, 40}, {50, 2}}},
+		&Stroke{Width: 2, Pts: [][2]int{{5, 5}, {9, 60}}},
+	})
+	tests := []struct {
+		name   string
+		limits Limits
+		data   []byte
+		want   error
+	}{
+		{"truncated header", DefaultLimits, deflate([]byte{'V', 3, 5, 1}),
+			&TruncatedError{What: "header", Offset: 2}},
+		{"truncated command list", DefaultLimits, deflate([]byte{'V', 3, 0, 2, 2}),
+			&TruncatedError{What: "command list", Offset: 5}},
+		{"truncated stroke", DefaultLimits, deflate([]byte{'V', 3, 0, 1, 1, 0, 0, 0, 2, 2, 4}),
+			&TruncatedError{What: "stroke", Offset: 4}},
+		{"truncated fill", DefaultLimits, deflate([]byte{'V', 3, 0, 1, 3, 9}),
+			&TruncatedError{What: "fill", Offset: 4}},
+		{"truncated trailer", DefaultLimits, deflate([]byte{'V', 3, 0, 1, 2, 7, 9}),
+			&TruncatedError{What: "trailer", Offset: 5}},
+		{"joined command not a stroke", DefaultLimits, deflate([]byte{'V', 3, 0, 1, 2, 3, 2, 1, 0}),
+			&TruncatedError{What: "trailer", Offset: 5}},
+		{"joined command out of range", DefaultLimits, deflate([]byte{'V', 3, 0, 1, 2, 3, 2, 1, 1}),
+			&TruncatedError{What: "trailer", Offset: 5}},
+		{"unknown tag", DefaultLimits, deflate([]byte{'V', 3, 0, 2, 2, 0x09}),
+			&UnknownTagError{Tag: 0x09, Offset: 5}},
+		{"timed stroke in v2", DefaultLimits, deflate([]byte{'V', 2, 1, 4}),
+			&UnknownTagError{Tag: 0x04, Offset: 3}},
+		{"commands", Limits{MaxCmds: 1}, strokes, &LimitError{What: "commands", Limit: 1}},
+		{"points", Limits{MaxPoints: 4}, strokes, &LimitError{What: "points", Limit: 4}},
+		{"points per stroke", Limits{MaxPoints: 4, MaxStrokePoints: 2}, strokes,
+			&LimitError{What: "points per stroke", Limit: 2}},
+		{"decompressed bytes", Limits{MaxDecompressed: 8}, strokes,
+			&LimitError{What: "decompressed bytes", Limit: 8}},
+		{"canvas width", DefaultLimits, EncodeWithHeader(Header{Width: 4096, Height: 64}, nil),
+			&LimitError{What: "canvas width", Limit: 2048}},
+		{"canvas height", DefaultLimits, EncodeWithHeader(Header{Width: 64, Height: 60000}, nil),
+			&LimitError{What: "canvas height", Limit: 2048}},
+		{"canvas width too small", DefaultLimits, EncodeWithHeader(Header{Width: 63, Height: 64}, nil),
+			&RangeError{What: "canvas width", Value: 63, Min: 64, Max: 1 << 16}},
+		{"canvas height too large", Limits{}, EncodeWithHeader(Header{Width: 64, Height: 1<<16 + 1}, nil),
+			&RangeError{What: "canvas height", Value: 1<<16 + 1, Min: 64, Max: 1 << 16}},
+		{"version", DefaultLimits, deflate([]byte{'V', 9, 0}), &VersionError{Version: 9}},
+		{"not vector", DefaultLimits, deflate([]byte("hello")), ErrNotVector},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := tt.limits.Decode(tt.data)
+			if !reflect.DeepEqual(err, tt.want) {
+				t.Errorf("Decode error = %#v, want %#v", err, tt.want)
+			}
+		})
+	}
+
+	t.Run("flate", func(t *testing.T) {
+		_, _, err := Decode([]byte{0xFF, 0xFF})
+		var flateErr *FlateError
+		if !errors.As(err, &flateErr) {
+			t.Errorf("Decode error = %v, want a *FlateError", err)
+		}
+	})
+	t.Run("legacy width", func(t *testing.T) {
+		_, err := Limits{MaxLegacyWidth: 4}.DecodeLegacyBitmap(legacyPayload(0, 0, 8, 8, make([]byte, 24)))
+		if want := (&LimitError{What: "legacy width", Limit: 4}); !reflect.DeepEqual(err, want) {
+			t.Errorf("DecodeLegacyBitmap error = %v, want %v", err, want)
+		}
+	})
+	t.Run("legacy truncated", func(t *testing.T) {
+		_, err := DecodeLegacyBitmap([]byte{0, 0, 0, 0, 1, 0})
+		if want := (&TruncatedError{What: "legacy bitmap", Offset: 6}); !reflect.DeepEqual(err, want) {
+			t.Errorf("DecodeLegacyBitmap error = %v, want %v", err, want)
+		}
+	})
+}
+
+func deflate(raw []byte) []byte {
+	var out bytes.Buffer
+	flw, _ := flate.NewWriter(&out, 9)
+	flw.Write(raw)
+	flw.Close()
+	return out.Bytes()
+}
+
+func mustBase64(t *testing.T, s string) []byte {
+	t.Helper()
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
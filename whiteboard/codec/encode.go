@@ -0,0 +1,262 @@
+package codec
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"math"
+)
+
+// Encode serialises cmds into the binary wire format without canvas metadata
+// and FLATE-compresses it.
+func Encode(cmds []Cmd) []byte {
+	return EncodeWithHeader(Header{}, cmds)
+}
+
+// EncodeWithHeader serialises cmds, preceded by the canvas size and background
+// from h (omitted when h.Width or h.Height is 0), h.Policy and h.Undone
+// (omitted when zero), into the binary wire format and FLATE-compresses it, with h.Branches
+// in a trailer field when there are any. h.Version and h.Signature are
+// ignored; the current version is written.
+//
+// Compression techniques applied:
+//  1. RDP simplification  — removes near-collinear points per stroke (lossless at 1px epsilon)
+//  2. Varint deltas        — 1 byte for |delta|<=63 (covers the bulk of mouse move steps),
+//     growing as needed instead of wrapping; counts are varints too
+//  3. FLATE level-9        — compresses the already-compact binary further
+func EncodeWithHeader(h Header, cmds []Cmd) []byte {
+	raw := encodeRaw(h, cmds)
+	return compress(raw.Bytes())
+}
+
+// encodeRaw serialises h and cmds into the uncompressed wire format.
+func encodeRaw(h Header, cmds []Cmd) *bytes.Buffer {
+	var raw bytes.Buffer
+	raw.WriteByte(vecMagic)
+	raw.WriteByte(vecVersion)
+
+	var hdr bytes.Buffer
+	if h.Width > 0 && h.Height > 0 {
+		var canvasField bytes.Buffer
+		writeUvarint(&canvasField, uint64(h.Width))
+		writeUvarint(&canvasField, uint64(h.Height))
+		canvasField.Write([]byte{h.Bg.R, h.Bg.G, h.Bg.B})
+		hdr.WriteByte(vecHdrCanvas)
+		writeUvarint(&hdr, uint64(canvasField.Len()))
+		hdr.Write(canvasField.Bytes())
+	}
+	h.Policy.writeField(&hdr, vecHdrPolicy)
+	if h.Undone > 0 {
+		var undoneField bytes.Buffer
+		writeUvarint(&undoneField, uint64(h.Undone))
+		hdr.WriteByte(vecHdrUndone)
+		writeUvarint(&hdr, uint64(undoneField.Len()))
+		hdr.Write(undoneField.Bytes())
+	}
+	writeUvarint(&raw, uint64(hdr.Len()))
+	raw.Write(hdr.Bytes())
+
+	writeUvarint(&raw, uint64(len(cmds)))
+	var cw cmdWriter
+	cw.write(&raw, cmds)
+	if len(h.Branches) > 0 {
+		var field bytes.Buffer
+		writeUvarint(&field, uint64(len(h.Branches)))
+		for _, b := range h.Branches {
+			writeUvarint(&field, uint64(b.Fork))
+			writeUvarint(&field, uint64(len(b.Cmds)))
+			cw.write(&field, b.Cmds)
+		}
+		raw.WriteByte(vecTrlBranches)
+		writeUvarint(&raw, uint64(field.Len()))
+		raw.Write(field.Bytes())
+	}
+	if len(cw.joined) > 0 {
+		var field bytes.Buffer
+		writeUvarint(&field, uint64(len(cw.joined)))
+		prev := -1
+		for _, i := range cw.joined {
+			writeUvarint(&field, uint64(i-prev-1))
+			prev = i
+		}
+		raw.WriteByte(vecTrlGroups)
+		writeUvarint(&raw, uint64(field.Len()))
+		raw.Write(field.Bytes())
+	}
+	return &raw
+}
+
+// cmdWriter writes command lists. One writer serves every list of a payload,
+// as the gaps of CMD_STROKE_T count from the previous timed stroke written.
+type cmdWriter struct {
+	lastTime int64 // end of the previous timed stroke
+	timed    bool  // whether there was one
+	n        int   // commands written that decode; empty strokes do not
+	joined   []int // indices of the joined strokes among them
+}
+
+// write appends the encoding of cmds to raw, without their count.
+func (w *cmdWriter) write(raw *bytes.Buffer, cmds []Cmd) {
+	for _, cmd := range cmds {
+		switch c := cmd.(type) {
+		case *Stroke:
+			tag := vecTagStroke
+			if c.Timed() {
+				tag = vecTagStrokeT
+			}
+			raw.WriteByte(tag)
+			raw.WriteByte(c.R)
+			raw.WriteByte(c.G)
+			raw.WriteByte(c.B)
+			raw.WriteByte(c.Width)
+			// Simplify points with RDP before encoding.
+			keep := simplifyIdx(c.Pts)
+			writeUvarint(raw, uint64(len(keep)))
+			if len(keep) == 0 {
+				continue
+			}
+			if c.Joined {
+				w.joined = append(w.joined, w.n)
+			}
+			w.n++
+			// First point: absolute coords.
+			writeVarint(raw, int64(c.Pts[keep[0]][0]))
+			writeVarint(raw, int64(c.Pts[keep[0]][1]))
+			// Subsequent points: signed deltas.
+			for i := 1; i < len(keep); i++ {
+				writeVarint(raw, int64(c.Pts[keep[i]][0]-c.Pts[keep[i-1]][0]))
+				writeVarint(raw, int64(c.Pts[keep[i]][1]-c.Pts[keep[i-1]][1]))
+			}
+			if tag != vecTagStrokeT {
+				continue
+			}
+			// A dropped point's time is folded into the next kept point's dt,
+			// so the pace of the stroke survives simplification. Times that
+			// run backwards are written as no delay.
+			t := c.Times[keep[0]]
+			if !w.timed {
+				w.lastTime, w.timed = t, true
+			}
+			writeUvarint(raw, uint64(max(t-w.lastTime, 0)))
+			w.lastTime = max(t, w.lastTime)
+			for _, k := range keep[1:] {
+				writeUvarint(raw, uint64(max(c.Times[k]-w.lastTime, 0)))
+				w.lastTime = max(c.Times[k], w.lastTime)
+			}
+		case Clear:
+			raw.WriteByte(vecTagClear)
+			w.n++
+		case Fill:
+			raw.WriteByte(vecTagFill)
+			w.n++
+			raw.WriteByte(c.R)
+			raw.WriteByte(c.G)
+			raw.WriteByte(c.B)
+		}
+	}
+}
+
+// compress FLATE-compresses an uncompressed payload at level 9.
+func compress(raw []byte) []byte {
+	var out bytes.Buffer
+	flw, _ := flate.NewWriter(&out, 9)
+	flw.Write(raw)
+	flw.Close()
+	return out.Bytes()
+}
+
+// writeUvarint appends v to buf in the encoding/binary unsigned varint form.
+func writeUvarint(buf *bytes.Buffer, v uint64) {
+	var tmp [binary.MaxVarintLen64]byte
+	buf.Write(tmp[:binary.PutUvarint(tmp[:], v)])
+}
+
+// writeVarint appends v to buf in the encoding/binary zig-zag varint form, so
+// small deltas of either sign cost a single byte.
+func writeVarint(buf *bytes.Buffer, v int64) {
+	var tmp [binary.MaxVarintLen64]byte
+	buf.Write(tmp[:binary.PutVarint(tmp[:], v)])
+}
+
+// rdpEpsilon is the perpendicular-distance threshold (in pixels) below which
+// intermediate points are considered collinear and removed. 1.0 px is lossless
+// at any pen width >= 2 px (the removed points fall inside the stroke anyway).
+const rdpEpsilon = 1.0
+
+// rdpWorkPerPoint bounds simplification to this many distance tests per
+// point of a stroke. Ramer-Douglas-Peucker needs about log2(n) per point for
+// strokes drawn by hand, but n/2 for a zig-zag, so a crafted stroke could
+// otherwise stall the encoder; spans left when the budget runs out keep all
+// their points, which costs size but not accuracy.
+const rdpWorkPerPoint = 32
+
+// rdpFarthest returns the point of pts strictly between lo and hi that lies
+// farthest from the line through pts[lo] and pts[hi], and its distance.
+func rdpFarthest(pts [][2]int, lo, hi int) (int, float64) {
+	ax, ay := float64(pts[lo][0]), float64(pts[lo][1])
+	bx, by := float64(pts[hi][0]), float64(pts[hi][1])
+	dx, dy := bx-ax, by-ay
+	lineLenSq := dx*dx + dy*dy
+	maxDist, maxIdx := 0.0, lo+1
+	for i := lo + 1; i < hi; i++ {
+		px, py := float64(pts[i][0]), float64(pts[i][1])
+		var dist float64
+		if lineLenSq == 0 {
+			dist = math.Sqrt((px-ax)*(px-ax) + (py-ay)*(py-ay))
+		} else {
+			// Perpendicular distance = |cross product| / line length
+			cross := (px-ax)*dy - (py-ay)*dx
+			if cross < 0 {
+				cross = -cross
+			}
+			dist = cross / math.Sqrt(lineLenSq)
+		}
+		if dist > maxDist {
+			maxDist, maxIdx = dist, i
+		}
+	}
+	return maxIdx, maxDist
+}
+
+// simplifyIdx runs Ramer-Douglas-Peucker simplification over a stroke's
+// absolute points and returns the indices of the points to keep, in order.
+// Spans wait on a stack rather than in recursion, so a long stroke cannot
+// exhaust the goroutine stack either.
+func simplifyIdx(pts [][2]int) []int {
+	if len(pts) <= 2 {
+		keep := make([]int, len(pts))
+		for i := range keep {
+			keep[i] = i
+		}
+		return keep
+	}
+	kept := make([]bool, len(pts))
+	kept[0], kept[len(pts)-1] = true, true
+	work := rdpWorkPerPoint * len(pts)
+	spans := [][2]int{{0, len(pts) - 1}}
+	for len(spans) > 0 {
+		lo, hi := spans[len(spans)-1][0], spans[len(spans)-1][1]
+		spans = spans[:len(spans)-1]
+		if hi <= lo+1 {
+			continue
+		}
+		if work < hi-lo-1 {
+			for i := lo + 1; i < hi; i++ {
+				kept[i] = true
+			}
+			continue
+		}
+		work -= hi - lo - 1
+		if i, dist := rdpFarthest(pts, lo, hi); dist > rdpEpsilon {
+			kept[i] = true
+			spans = append(spans, [2]int{lo, i}, [2]int{i, hi})
+		}
+	}
+	var keep []int
+	for i, k := range kept {
+		if k {
+			keep = append(keep, i)
+		}
+	}
+	return keep
+}
@@ -0,0 +1,106 @@
+package codec
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	// ErrNotVector is returned by Decode when data decompresses but does not
+	// hold a vector payload; such data may still be a legacy bitmap.
+	ErrNotVector = errors.New("codec: not a vector payload")
+
+	// ErrAuth is returned by Open when the password is wrong or the encrypted
+	// data was altered; AES-GCM cannot tell the two apart.
+	ErrAuth = errors.New("codec: authentication failed")
+
+	// ErrNotEncrypted is returned by Open for data without an encryption marker.
+	ErrNotEncrypted = errors.New("codec: payload is not encrypted")
+
+	// ErrNeedsPassword is returned by Keys.Unseal for a password protected
+	// payload when no password was given.
+	ErrNeedsPassword = errors.New("codec: payload is password protected")
+
+	// ErrNeedsKey is returned by Open for an envelope written by SealWithKey;
+	// no password opens it, only the key that was shared with the link.
+	ErrNeedsKey = errors.New("codec: payload needs the secret link key")
+
+	// ErrRecipientsOnly is returned by Open for an envelope sealed to public
+	// keys, and by OpenForRecipient when the private key is not among them.
+	ErrRecipientsOnly = errors.New("codec: payload is encrypted for its listed recipients only")
+
+	// ErrNotPNG is returned by EmbedInPNG and ExtractFromPNG for data without
+	// the PNG file signature.
+	ErrNotPNG = errors.New("codec: not a PNG file")
+
+	// ErrNoPNGPayload is returned by ExtractFromPNG for a PNG file that was not
+	// saved by the whiteboard and so carries no share payload.
+	ErrNoPNGPayload = errors.New("codec: PNG file carries no whiteboard payload")
+)
+
+// TruncatedError reports a payload that ends, or stops making sense, in the
+// middle of an element. Offset is a byte offset into the decompressed payload
+// (into the raw data for legacy bitmaps).
+type TruncatedError struct {
+	What   string // "header", "command list", "stroke", "fill", "trailer", "legacy bitmap", "png"
+	Offset int
+}
+
+func (e *TruncatedError) Error() string {
+	return fmt.Sprintf("codec: truncated %s at offset %d", e.What, e.Offset)
+}
+
+// RangeError reports a header value outside the range the format allows,
+// such as a canvas side below MinCanvasSize.
+type RangeError struct {
+	What     string // "canvas width", "canvas height"
+	Value    uint64
+	Min, Max int
+}
+
+func (e *RangeError) Error() string {
+	return fmt.Sprintf("codec: %s %d outside %d..%d", e.What, e.Value, e.Min, e.Max)
+}
+
+// UnknownTagError reports a command tag this version does not know, which
+// usually means the payload was written by a newer version.
+type UnknownTagError struct {
+	Tag    byte
+	Offset int
+}
+
+func (e *UnknownTagError) Error() string {
+	return fmt.Sprintf("codec: unknown tag 0x%02X at offset %d", e.Tag, e.Offset)
+}
+
+// VersionError reports a vector payload in a wire format version this package
+// cannot read.
+type VersionError struct {
+	Version byte
+}
+
+func (e *VersionError) Error() string {
+	return fmt.Sprintf("codec: unsupported vector format version 0x%02X", e.Version)
+}
+
+// FlateError reports data that is not a valid FLATE stream, typically a link
+// that was cut short when copied.
+type FlateError struct {
+	Err error
+}
+
+func (e *FlateError) Error() string {
+	return "codec: FLATE decompression failed: " + e.Err.Error()
+}
+
+func (e *FlateError) Unwrap() error { return e.Err }
+
+// ExpiredError reports a link whose Policy expiry has passed.
+type ExpiredError struct {
+	Expires time.Time
+}
+
+func (e *ExpiredError) Error() string {
+	return "codec: link expired at " + e.Expires.UTC().Format(time.RFC3339)
+}
@@ -0,0 +1,168 @@
+package codec
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+// fuzzLimits keeps each fuzz iteration cheap while still exercising every
+// limit check; the seed corpus in testdata/fuzz holds payloads that exceed them.
+var fuzzLimits = Limits{
+	MaxDecompressed: 1 << 16,
+	MaxCmds:         1 << 12,
+	MaxPoints:       1 << 14,
+	MaxStrokePoints: 1 << 12,
+	MaxLegacyWidth:  512,
+	MaxLegacyHeight: 512,
+	MaxCanvasWidth:  1024,
+	MaxCanvasHeight: 1024,
+}
+
+func legacyPayload(x, y, w, h uint16, plane []byte) []byte {
+	var raw bytes.Buffer
+	binary.Write(&raw, binary.LittleEndian, [4]uint16{x, y, w, h})
+	raw.Write(compressPlane(plane))
+	return raw.Bytes()
+}
+
+func FuzzDecode(f *testing.F) {
+	signed, _ := EncodeSigned(Header{Width: 64, Height: 64, Bg: White},
+		[]Cmd{&Stroke{G: 255, Width: 3, Pts: [][2]int{{1, 2}, {30, 40}}}},
+		ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize)))
+	f.Add(signed)
+	f.Add(Encode([]Cmd{
+		&Stroke{Width: 2, Pts: [][2]int{{0, 0}, {5, 9}, {40, 3}}, Times: []int64{1000, 1016, 1050}},
+		Clear{},
+		&Stroke{R: 9, Width: 5, Pts: [][2]int{{7, 7}}, Times: []int64{1900}},
+	}))
+	f.Add(Encode([]Cmd{
+		Fill{R: 10, G: 20, B: 30},
+		&Stroke{R: 255, Width: 4, Pts: [][2]int{{5, 5}}},
+		&Stroke{B: 255, Width: 2, Pts: [][2]int{{0, 0}, {300, 10}, {-5, 400}, {2047, 2047}}},
+		Clear{},
+	}))
+	f.Add(EncodeWithHeader(Header{Width: 800, Height: 600, Bg: White}, nil))
+	f.Add(EncodeWithHeader(Header{Undone: 1, Branches: []Branch{
+		{Fork: 1, Cmds: []Cmd{&Stroke{Width: 3, Pts: [][2]int{{4, 4}, {9, 2}}, Times: []int64{2000, 2040}}}},
+		{Fork: 0, Cmds: []Cmd{Fill{R: 200}}},
+	}}, []Cmd{&Stroke{Width: 2, Pts: [][2]int{{1, 1}}, Times: []int64{1000}}, Clear{}}))
+	f.Add(EncodeWithHeader(Header{Branches: []Branch{
+		{Fork: 1, Cmds: []Cmd{&Stroke{Width: 1, Pts: [][2]int{{2, 2}}, Joined: true}}},
+	}}, []Cmd{
+		&Stroke{Width: 2, Pts: [][2]int{{1, 1}, {5, 5}}},
+		&Stroke{Width: 2, Pts: [][2]int{{6, 6}}, Joined: true},
+	}))
+	// v1: one stroke with a 1-byte and a 3-byte readDelta pair.
+	f.Add(deflate([]byte{'V', 1, 1, 0, 1, 1, 2, 3, 4, 3, 0, 10, 0, 20, 0, 0x85, 3, 0xFF, 0x00, 0x01, 0x81}))
+	f.Add(deflate([]byte{'V', 2, 1, 3, 1, 2, 3}))
+	f.Add([]byte{})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		cmds, hdr, err := fuzzLimits.Decode(data)
+		if err != nil {
+			return
+		}
+		if hdr.Width > fuzzLimits.MaxCanvasWidth || hdr.Height > fuzzLimits.MaxCanvasHeight {
+			t.Fatalf("decoded a %dx%d canvas, limit %dx%d", hdr.Width, hdr.Height,
+				fuzzLimits.MaxCanvasWidth, fuzzLimits.MaxCanvasHeight)
+		}
+		if hdr.Undone < 0 || hdr.Undone > len(cmds) {
+			t.Fatalf("%d of %d commands undone", hdr.Undone, len(cmds))
+		}
+		all := cmds
+		for _, b := range hdr.Branches {
+			if b.Fork < 0 || b.Fork > len(cmds) {
+				t.Fatalf("branch forks at %d of %d commands", b.Fork, len(cmds))
+			}
+			all = append(all[:len(all):len(all)], b.Cmds...)
+		}
+		if len(all) > fuzzLimits.MaxCmds {
+			t.Fatalf("decoded %d commands, limit %d", len(all), fuzzLimits.MaxCmds)
+		}
+		total, joined := 0, 0
+		for _, cmd := range all {
+			if s, ok := cmd.(*Stroke); ok {
+				if s.Joined {
+					joined++
+				}
+				if len(s.Pts) == 0 {
+					t.Fatal("decoded an empty stroke")
+				}
+				if len(s.Pts) > fuzzLimits.MaxStrokePoints {
+					t.Fatalf("decoded a stroke of %d points, limit %d", len(s.Pts), fuzzLimits.MaxStrokePoints)
+				}
+				if s.Times != nil && !s.Timed() {
+					t.Fatalf("decoded %d times for %d points", len(s.Times), len(s.Pts))
+				}
+				for i := 1; i < len(s.Times); i++ {
+					if s.Times[i] < s.Times[i-1] {
+						t.Fatalf("decoded times run backwards: %v", s.Times)
+					}
+				}
+				total += len(s.Pts)
+			}
+		}
+		if total > fuzzLimits.MaxPoints {
+			t.Fatalf("decoded %d points, limit %d", total, fuzzLimits.MaxPoints)
+		}
+		// Re-encoding skips FLATE, whose level-9 writer costs more than the
+		// rest of an iteration together.
+		raw := encodeRaw(Header{Undone: hdr.Undone, Branches: hdr.Branches}, cmds)
+		again, againHdr, err := DefaultLimits.parse(raw.Bytes())
+		if err != nil {
+			t.Fatalf("re-encoded payload does not decode: %v", err)
+		}
+		if len(again) != len(cmds) {
+			t.Fatalf("re-encoded payload has %d commands, want %d", len(again), len(cmds))
+		}
+		if againHdr.Undone != hdr.Undone {
+			t.Fatalf("re-encoded payload has %d commands undone, want %d", againHdr.Undone, hdr.Undone)
+		}
+		if len(againHdr.Branches) != len(hdr.Branches) {
+			t.Fatalf("re-encoded payload has %d branches, want %d", len(againHdr.Branches), len(hdr.Branches))
+		}
+		againJoined := 0
+		for _, b := range againHdr.Branches {
+			again = append(again, b.Cmds...)
+		}
+		for _, cmd := range again {
+			if s, ok := cmd.(*Stroke); ok && s.Joined {
+				againJoined++
+			}
+		}
+		if againJoined != joined {
+			t.Fatalf("re-encoded payload has %d joined strokes, want %d", againJoined, joined)
+		}
+	})
+}
+
+func FuzzDecodeLegacyBitmap(f *testing.F) {
+	f.Add(legacyPayload(3, 4, 2, 2, []byte{0xE0, 0, 0}))
+	f.Add(legacyPayload(0, 0, 64, 64, make([]byte, 64*64*3/8)))
+	f.Add([]byte{0, 0, 0, 0, 1, 0, 1, 0})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		bm, err := fuzzLimits.DecodeLegacyBitmap(data)
+		if err != nil {
+			var limErr *LimitError
+			if errors.As(err, &limErr) && limErr.Limit == 0 {
+				t.Fatalf("limit error without a limit: %v", err)
+			}
+			return
+		}
+		r := bm.Bounds()
+		if r.Dx() > fuzzLimits.MaxLegacyWidth || r.Dy() > fuzzLimits.MaxLegacyHeight {
+			t.Fatalf("decoded %v, limit %dx%d", r, fuzzLimits.MaxLegacyWidth, fuzzLimits.MaxLegacyHeight)
+		}
+		if len(bm.bits) > (r.Dx()*r.Dy()*3+7)/8+255 {
+			t.Fatalf("plane holds %d bytes for a %v bitmap", len(bm.bits), r)
+		}
+		if !r.Empty() {
+			bm.At(r.Min.X, r.Min.Y)
+			bm.At(r.Max.X-1, r.Max.Y-1)
+		}
+	})
+}
@@ -0,0 +1,180 @@
+package codec
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+)
+
+// LegacyBitmap is a decoded pre-vector share payload: a 3-bit RGB image placed
+// at an offset on the canvas. It implements image.Image with Bounds() already
+// translated to canvas coordinates, so it can be composited with image/draw.
+type LegacyBitmap struct {
+	rect image.Rectangle
+	bits []byte // interleaved R,G,B bits, MSB first, row-major
+}
+
+// DecodeLegacyBitmap decodes a legacy bitmap within DefaultLimits.
+func DecodeLegacyBitmap(data []byte) (*LegacyBitmap, error) {
+	return DefaultLimits.DecodeLegacyBitmap(data)
+}
+
+// DecodeLegacyBitmap decodes the original bitmap share format, kept for
+// back-compat with URLs generated before the vector format was introduced:
+//
+//	offsetX uint16LE | offsetY uint16LE | width uint16LE | height uint16LE
+//	| FLATE( RLE( 3 bits per pixel ) )
+func (l Limits) DecodeLegacyBitmap(data []byte) (*LegacyBitmap, error) {
+	if len(data) <= 8 {
+		return nil, &TruncatedError{What: "legacy bitmap", Offset: len(data)}
+	}
+	offsetX := int(binary.LittleEndian.Uint16(data[0:2]))
+	offsetY := int(binary.LittleEndian.Uint16(data[2:4]))
+	width := int(binary.LittleEndian.Uint16(data[4:6]))
+	height := int(binary.LittleEndian.Uint16(data[6:8]))
+	if l.MaxLegacyWidth > 0 && width > l.MaxLegacyWidth {
+		return nil, &LimitError{What: "legacy width", Limit: l.MaxLegacyWidth}
+	}
+	if l.MaxLegacyHeight > 0 && height > l.MaxLegacyHeight {
+		return nil, &LimitError{What: "legacy height", Limit: l.MaxLegacyHeight}
+	}
+	compressedData := data[8:]
+	interleavedBits, err := decompressPlane(compressedData, l.MaxDecompressed, (width*height*3+7)/8)
+	if err != nil {
+		var limErr *LimitError
+		if errors.As(err, &limErr) {
+			return nil, err
+		}
+		return nil, &FlateError{Err: err}
+	}
+	return &LegacyBitmap{
+		rect: image.Rect(offsetX, offsetY, offsetX+width, offsetY+height),
+		bits: interleavedBits,
+	}, nil
+}
+
+// ColorModel implements image.Image.
+func (b *LegacyBitmap) ColorModel() color.Model { return color.RGBAModel }
+
+// Bounds implements image.Image; the rectangle is in canvas coordinates.
+func (b *LegacyBitmap) Bounds() image.Rectangle { return b.rect }
+
+// At implements image.Image. Pixels past the end of the decoded bit stream are
+// black, as in the original decoder.
+func (b *LegacyBitmap) At(x, y int) color.Color {
+	if !(image.Point{x, y}.In(b.rect)) {
+		return color.RGBA{}
+	}
+	bitIdx := ((y-b.rect.Min.Y)*b.rect.Dx() + (x - b.rect.Min.X)) * 3
+	getBit := func() byte {
+		bi := bitIdx / 8
+		bp := uint(7 - (bitIdx % 8))
+		bitIdx++
+		if bi < len(b.bits) && (b.bits[bi]&(1<<bp)) != 0 {
+			return 255
+		}
+		return 0
+	}
+	r, g, bl := getBit(), getBit(), getBit()
+	return color.RGBA{r, g, bl, 255}
+}
+
+func compressPlane(data []byte) []byte {
+	// Apply RLE first for runs of identical bytes
+	rleData := runLengthEncode(data)
+
+	// Then compress with FLATE
+	var buf bytes.Buffer
+	// FLATE compression with level 9 (best compression)
+	flw, _ := flate.NewWriter(&buf, 9)
+	flw.Write(rleData)
+	flw.Close()
+	return buf.Bytes()
+}
+
+// decompressPlane undoes compressPlane. FLATE output is capped at maxFlate
+// bytes; RLE output stops at planeSize bytes, the most the bitmap can use.
+func decompressPlane(data []byte, maxFlate, planeSize int) ([]byte, error) {
+	// Decompress FLATE first
+	rle, err := inflate(data, maxFlate)
+	if err != nil {
+		return nil, err
+	}
+
+	// Then decode RLE
+	return runLengthDecode(rle, planeSize), nil
+}
+
+func runLengthEncode(data []byte) []byte {
+	if len(data) == 0 {
+		return data
+	}
+
+	var result bytes.Buffer
+	i := 0
+
+	for i < len(data) {
+		currentByte := data[i]
+		runLength := 1
+
+		// Count consecutive identical bytes (max 255)
+		for i+1 < len(data) && data[i+1] == currentByte && runLength < 255 {
+			i++
+			runLength++
+		}
+
+		if runLength >= 3 {
+			// Use RLE for runs of 3 or more: [marker=255][byte][count]
+			result.WriteByte(255) // RLE marker
+			result.WriteByte(currentByte)
+			result.WriteByte(byte(runLength))
+		} else {
+			// For short runs, write literally
+			for j := 0; j < runLength; j++ {
+				// If byte is 255 (marker), escape it
+				if currentByte == 255 {
+					result.WriteByte(255)
+					result.WriteByte(255)
+					result.WriteByte(1) // Run of 1
+				} else {
+					result.WriteByte(currentByte)
+				}
+			}
+		}
+
+		i++
+	}
+
+	return result.Bytes()
+}
+
+// runLengthDecode expands RLE data, stopping once max bytes are produced.
+func runLengthDecode(data []byte, max int) []byte {
+	if len(data) == 0 {
+		return data
+	}
+
+	var result bytes.Buffer
+	i := 0
+
+	for i < len(data) && result.Len() < max {
+		if data[i] == 255 && i+2 < len(data) {
+			// RLE sequence: [255][byte][count]
+			byteVal := data[i+1]
+			count := int(data[i+2])
+			for j := 0; j < count; j++ {
+				result.WriteByte(byteVal)
+			}
+			i += 3
+		} else {
+			// Literal byte
+			result.WriteByte(data[i])
+			i++
+		}
+	}
+
+	return result.Bytes()
+}
@@ -0,0 +1,65 @@
+package codec
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"io"
+)
+
+// Limits bounds the memory a decoder may spend on untrusted share links.
+// A zero field means no limit for that resource.
+type Limits struct {
+	MaxDecompressed int // bytes produced by FLATE (vector payload or legacy plane)
+	MaxCmds         int // commands in a vector payload
+	MaxPoints       int // points over all strokes of a vector payload
+	MaxStrokePoints int // points of a single stroke
+	MaxLegacyWidth  int // legacy bitmap width in pixels
+	MaxLegacyHeight int // legacy bitmap height in pixels
+	MaxCanvasWidth  int // canvas width a vector payload header asks for
+	MaxCanvasHeight int // canvas height a vector payload header asks for
+}
+
+// DefaultLimits is used by Decode, Load and DecodeLegacyBitmap. The canvas
+// limits are the largest board the whiteboard edits; the others are far above
+// anything it produces for such a board.
+var DefaultLimits = Limits{
+	MaxDecompressed: 16 << 20,
+	MaxCmds:         1 << 20,
+	MaxPoints:       4 << 20,
+	MaxStrokePoints: 1 << 16,
+	MaxLegacyWidth:  4096,
+	MaxLegacyHeight: 4096,
+	MaxCanvasWidth:  2048,
+	MaxCanvasHeight: 2048,
+}
+
+// LimitError reports a payload rejected because it exceeds one of the Limits.
+type LimitError struct {
+	What  string // "decompressed bytes", "commands", "points", "points per stroke", "legacy width", "legacy height", "canvas width", "canvas height"
+	Limit int
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("codec: payload exceeds the limit of %d %s", e.Limit, e.What)
+}
+
+// inflate decompresses a FLATE stream, failing with a *LimitError instead of
+// growing past max bytes (when max > 0).
+func inflate(data []byte, max int) ([]byte, error) {
+	flr := flate.NewReader(bytes.NewReader(data))
+	defer flr.Close()
+
+	var r io.Reader = flr
+	if max > 0 {
+		r = io.LimitReader(flr, int64(max)+1)
+	}
+	var buf bytes.Buffer
+	if _, err := io.Copy(&buf, r); err != nil {
+		return nil, err
+	}
+	if max > 0 && buf.Len() > max {
+		return nil, &LimitError{What: "decompressed bytes", Limit: max}
+	}
+	return buf.Bytes(), nil
+}
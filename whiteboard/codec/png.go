@@ -0,0 +1,99 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+)
+
+// PNG files saved by the whiteboard carry the share payload of the board in a
+// private ancillary chunk, so opening the file restores the editable history
+// rather than a flat picture. The chunk type spells out its properties: lower
+// case 'w' ancillary (viewers ignore it), lower case 'b' private, upper case
+// 'C' unsafe to copy (an editor that changes the pixels must drop it, as the
+// payload would no longer match them).
+const pngChunkType = "wbVC"
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// IsPNG reports whether data starts with the PNG file signature.
+func IsPNG(data []byte) bool {
+	return bytes.HasPrefix(data, pngSignature)
+}
+
+// EmbedInPNG returns a copy of the PNG file png with payload stored in a wbVC
+// chunk just before IEND, replacing any payload it carried already.
+func EmbedInPNG(png, payload []byte) ([]byte, error) {
+	out := make([]byte, 0, len(png)+len(payload)+12)
+	out = append(out, pngSignature...)
+	err := pngChunks(png, func(typ string, chunk []byte) {
+		switch typ {
+		case pngChunkType:
+			return
+		case "IEND":
+			var hdr [8]byte
+			binary.BigEndian.PutUint32(hdr[:4], uint32(len(payload)))
+			copy(hdr[4:], pngChunkType)
+			crc := crc32.NewIEEE()
+			crc.Write(hdr[4:])
+			crc.Write(payload)
+			out = append(out, hdr[:]...)
+			out = append(out, payload...)
+			out = binary.BigEndian.AppendUint32(out, crc.Sum32())
+		}
+		out = append(out, chunk...)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ExtractFromPNG returns the share payload a PNG file saved by the whiteboard
+// carries, or ErrNoPNGPayload when it has none. The payload is checked against
+// its chunk CRC; decoding it is up to Load.
+func ExtractFromPNG(data []byte) ([]byte, error) {
+	var payload []byte
+	err := pngChunks(data, func(typ string, chunk []byte) {
+		if typ == pngChunkType && payload == nil {
+			payload = chunk[8 : len(chunk)-4]
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	if payload == nil {
+		return nil, ErrNoPNGPayload
+	}
+	return payload, nil
+}
+
+// pngChunks calls fn for every chunk of a PNG file up to and including IEND,
+// with the chunk's type and its bytes from the length field through the CRC.
+// A chunk that runs past the end of data or fails its CRC is a
+// *TruncatedError.
+func pngChunks(data []byte, fn func(typ string, chunk []byte)) error {
+	if !IsPNG(data) {
+		return ErrNotPNG
+	}
+	pos := len(pngSignature)
+	for {
+		if len(data)-pos < 12 {
+			return &TruncatedError{What: "png", Offset: pos}
+		}
+		n := binary.BigEndian.Uint32(data[pos:])
+		if uint64(n) > uint64(len(data)-pos-12) {
+			return &TruncatedError{What: "png", Offset: pos}
+		}
+		end := pos + 12 + int(n)
+		if crc32.ChecksumIEEE(data[pos+4:end-4]) != binary.BigEndian.Uint32(data[end-4:]) {
+			return &TruncatedError{What: "png", Offset: pos}
+		}
+		typ := string(data[pos+4 : pos+8])
+		fn(typ, data[pos:end])
+		if typ == "IEND" {
+			return nil
+		}
+		pos = end
+	}
+}
@@ -0,0 +1,130 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+)
+
+// testPNG returns a small PNG file as image/png writes it.
+func testPNG(t *testing.T) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, 4, 3))
+	img.Set(1, 2, color.RGBA{R: 255, A: 255})
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// chunkTypes lists the chunk types of a PNG file in order.
+func chunkTypes(t *testing.T, data []byte) []string {
+	t.Helper()
+	var types []string
+	if err := pngChunks(data, func(typ string, chunk []byte) { types = append(types, typ) }); err != nil {
+		t.Fatalf("pngChunks: %v", err)
+	}
+	return types
+}
+
+func TestEmbedInPNG(t *testing.T) {
+	plain := testPNG(t)
+	payload := Encode(board)
+	withPayload, err := EmbedInPNG(plain, payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsPNG(withPayload) {
+		t.Fatal("EmbedInPNG output lacks the PNG signature")
+	}
+	types := chunkTypes(t, withPayload)
+	if n := len(types); n < 2 || types[n-2] != pngChunkType || types[n-1] != "IEND" {
+		t.Errorf("chunks = %v, want %s just before IEND", types, pngChunkType)
+	}
+
+	// Viewers still see the same picture.
+	img, err := png.Decode(bytes.NewReader(withPayload))
+	if err != nil {
+		t.Fatalf("png.Decode: %v", err)
+	}
+	if r, g, b, a := img.At(1, 2).RGBA(); r != 0xffff || g != 0 || b != 0 || a != 0xffff {
+		t.Errorf("pixel (1, 2) = %v, want red", img.At(1, 2))
+	}
+
+	got, err := ExtractFromPNG(withPayload)
+	if err != nil {
+		t.Fatalf("ExtractFromPNG: %v", err)
+	}
+	if !bytes.Equal(got, payload) {
+		t.Errorf("ExtractFromPNG = %x, want %x", got, payload)
+	}
+
+	// Saving again replaces the payload instead of adding a second chunk.
+	replacement := Encode(board[:1])
+	again, err := EmbedInPNG(withPayload, replacement)
+	if err != nil {
+		t.Fatal(err)
+	}
+	count := 0
+	for _, typ := range chunkTypes(t, again) {
+		if typ == pngChunkType {
+			count++
+		}
+	}
+	if count != 1 {
+		t.Errorf("%d %s chunks after embedding twice, want 1", count, pngChunkType)
+	}
+	if got, err := ExtractFromPNG(again); err != nil || !bytes.Equal(got, replacement) {
+		t.Errorf("ExtractFromPNG after re-embedding = %x, %v, want %x", got, err, replacement)
+	}
+
+	if _, err := EmbedInPNG(payload, payload); !errors.Is(err, ErrNotPNG) {
+		t.Errorf("EmbedInPNG of a non-PNG: %v, want ErrNotPNG", err)
+	}
+}
+
+func TestExtractFromPNG(t *testing.T) {
+	plain := testPNG(t)
+	if _, err := ExtractFromPNG(plain); !errors.Is(err, ErrNoPNGPayload) {
+		t.Errorf("PNG without a payload: %v, want ErrNoPNGPayload", err)
+	}
+	if _, err := ExtractFromPNG([]byte("GIF89a")); !errors.Is(err, ErrNotPNG) {
+		t.Errorf("GIF: %v, want ErrNotPNG", err)
+	}
+
+	withPayload, err := EmbedInPNG(plain, Encode(board))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A flipped payload byte fails the chunk CRC.
+	chunk := len(withPayload) - 12 - 12 - len(Encode(board)) // before IEND and the wbVC chunk
+	if typ := string(withPayload[chunk+4 : chunk+8]); typ != pngChunkType {
+		t.Fatalf("chunk at %d is %q, want %s", chunk, typ, pngChunkType)
+	}
+	corrupt := bytes.Clone(withPayload)
+	corrupt[chunk+8] ^= 1
+	var truncErr *TruncatedError
+	if _, err := ExtractFromPNG(corrupt); !errors.As(err, &truncErr) || truncErr.Offset != chunk {
+		t.Errorf("corrupted chunk: %v, want a *TruncatedError at %d", err, chunk)
+	}
+
+	// A chunk length running past the end of the file.
+	huge := bytes.Clone(withPayload)
+	binary.BigEndian.PutUint32(huge[chunk:], 1<<31)
+	if _, err := ExtractFromPNG(huge); !errors.As(err, &truncErr) {
+		t.Errorf("oversized chunk length: %v, want a *TruncatedError", err)
+	}
+
+	// Every cut short of IEND is an error, never a panic.
+	for n := 0; n < len(withPayload); n++ {
+		if _, err := ExtractFromPNG(withPayload[:n]); err == nil {
+			t.Fatalf("ExtractFromPNG of the first %d bytes succeeded", n)
+		}
+	}
+}
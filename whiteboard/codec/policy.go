@@ -0,0 +1,98 @@
+package codec
+
+import (
+	"bytes"
+	"time"
+)
+
+// ── Link policy ──────────────────────────────────────────────────────────────
+// ENV_POLICY (0x04) in an envelope header, HDR_POLICY (0x02) in a vector header:
+//   flags (1: bit 0 = read-only) | expires uvarint (Unix seconds, 0 = never)
+// In an envelope the field is GCM additional data, so it cannot be changed
+// without the key. In a plain payload it is only as trustworthy as the
+// payload's signature. Either way the viewer enforces it; it keeps honest
+// viewers honest, not a modified client.
+
+const policyReadOnly = byte(0x01)
+
+// Policy restricts how a shared board may be opened.
+type Policy struct {
+	Expires  time.Time // zero when the link never expires
+	ReadOnly bool      // open the board for viewing, not drawing
+}
+
+// IsZero reports whether p places no restriction.
+func (p Policy) IsZero() bool {
+	return p.Expires.IsZero() && !p.ReadOnly
+}
+
+// Merge returns the stricter combination of p and q: read-only if either is,
+// expiring at the earlier of their expiry times.
+func (p Policy) Merge(q Policy) Policy {
+	if q.ReadOnly {
+		p.ReadOnly = true
+	}
+	if !q.Expires.IsZero() && (p.Expires.IsZero() || q.Expires.Before(p.Expires)) {
+		p.Expires = q.Expires
+	}
+	return p
+}
+
+// Check returns an *ExpiredError if the link has expired at now.
+func (p Policy) Check(now time.Time) error {
+	if !p.Expires.IsZero() && !now.Before(p.Expires) {
+		return &ExpiredError{Expires: p.Expires}
+	}
+	return nil
+}
+
+// writeField appends p as a tag | len | value header field; nothing when p is
+// zero.
+func (p Policy) writeField(buf *bytes.Buffer, tag byte) {
+	if p.IsZero() {
+		return
+	}
+	var field bytes.Buffer
+	var flags byte
+	if p.ReadOnly {
+		flags |= policyReadOnly
+	}
+	field.WriteByte(flags)
+	var expires uint64
+	if !p.Expires.IsZero() {
+		expires = uint64(max(p.Expires.Unix(), 1))
+	}
+	writeUvarint(&field, expires)
+	buf.WriteByte(tag)
+	writeUvarint(buf, uint64(field.Len()))
+	buf.Write(field.Bytes())
+}
+
+// parsePolicy reads a policy field value. Flags this version does not know are
+// ignored, as are bytes after the expiry.
+func parsePolicy(field []byte) (Policy, bool) {
+	if len(field) < 2 {
+		return Policy{}, false
+	}
+	expires, n := readUvarint(field, 1)
+	if n == 0 || expires > 1<<62 {
+		return Policy{}, false
+	}
+	p := Policy{ReadOnly: field[0]&policyReadOnly != 0}
+	if expires > 0 {
+		p.Expires = time.Unix(int64(expires), 0)
+	}
+	return p, true
+}
+
+// EnvelopePolicy returns the policy in the header of a v2 envelope, or the
+// zero Policy for anything else. Before the envelope has been opened the
+// result is unauthenticated; once Open (or OpenWithKey, OpenForRecipient)
+// succeeded on the same data it is authentic.
+func EnvelopePolicy(data []byte) Policy {
+	env, err := parseEnvelope(data)
+	if err != nil {
+		return Policy{}
+	}
+	return env.policy
+}
@@ -0,0 +1,210 @@
+package codec
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+)
+
+// ── Public-key recipients ────────────────────────────────────────────────────
+// ENV_RECIPIENTS (0x03): ephemeral X25519 public key (32)
+//                      | { key id (8) | wrapped content key (48) } per recipient
+//   key id  = SHA-256(recipient public key)[:8]
+//   kek     = HKDF-SHA256(X25519(ephemeral, recipient),
+//                         salt = ephemeral public || recipient public,
+//                         info = "whiteboard recipient key", 32)
+//   wrapped = AES-256-GCM(kek, nonce = 0, content key)
+// Every kek seals exactly one key, so the fixed nonce is never reused under a
+// key. The random content key then seals the payload like any v2 envelope, and
+// the recipient list is part of the authenticated header.
+
+const (
+	keyIDSize      = 8
+	wrappedKeySize = KeySize + 16 // content key || GCM tag
+	recipientInfo  = "whiteboard recipient key"
+)
+
+// wrappedKey is the content key sealed for one recipient.
+type wrappedKey struct {
+	id      []byte // KeyID of the recipient public key
+	wrapped []byte
+}
+
+// NewKeyPair generates an X25519 key pair for receiving boards sealed with
+// SealForRecipients.
+func NewKeyPair() (*ecdh.PrivateKey, error) {
+	return ecdh.X25519().GenerateKey(rand.Reader)
+}
+
+// ParsePublicKey parses a 32-byte X25519 public key.
+func ParsePublicKey(b []byte) (*ecdh.PublicKey, error) {
+	return ecdh.X25519().NewPublicKey(b)
+}
+
+// ParsePrivateKey parses a 32-byte X25519 private key.
+func ParsePrivateKey(b []byte) (*ecdh.PrivateKey, error) {
+	return ecdh.X25519().NewPrivateKey(b)
+}
+
+// KeyID returns the short identifier an envelope lists a recipient under.
+func KeyID(pub []byte) []byte {
+	sum := sha256.Sum256(pub)
+	return sum[:keyIDSize]
+}
+
+// Fingerprint formats the SHA-256 of a public key as eight groups of four hex
+// digits, short enough to compare by reading it out.
+func Fingerprint(pub []byte) string {
+	sum := sha256.Sum256(pub)
+	digits := hex.EncodeToString(sum[:16])
+	groups := make([]string, 0, 8)
+	for i := 0; i < len(digits); i += 4 {
+		groups = append(groups, digits[i:i+4])
+	}
+	return strings.Join(groups, " ")
+}
+
+// SealForRecipients encrypts a compressed payload into a v2 envelope that only
+// the holders of the private keys for recipients can open. Duplicate keys are
+// listed once.
+func SealForRecipients(payload []byte, recipients []*ecdh.PublicKey) ([]byte, error) {
+	return Policy{}.SealForRecipients(payload, recipients)
+}
+
+// SealForRecipients is like the package-level SealForRecipients and binds p
+// into the envelope header.
+func (p Policy) SealForRecipients(payload []byte, recipients []*ecdh.PublicKey) ([]byte, error) {
+	if len(recipients) == 0 {
+		return nil, errors.New("codec: no recipients")
+	}
+	contentKey, err := NewKey()
+	if err != nil {
+		return nil, err
+	}
+	ephemeral, err := NewKeyPair()
+	if err != nil {
+		return nil, err
+	}
+
+	var field bytes.Buffer
+	field.Write(ephemeral.PublicKey().Bytes())
+	seen := make(map[string]bool)
+	for _, pub := range recipients {
+		id := KeyID(pub.Bytes())
+		if seen[string(id)] {
+			continue
+		}
+		seen[string(id)] = true
+		shared, err := ephemeral.ECDH(pub)
+		if err != nil {
+			return nil, err
+		}
+		kek, err := recipientKEK(shared, ephemeral.PublicKey().Bytes(), pub.Bytes())
+		if err != nil {
+			return nil, err
+		}
+		gcm, err := newGCM(kek)
+		if err != nil {
+			return nil, err
+		}
+		field.Write(id)
+		field.Write(gcm.Seal(nil, make([]byte, gcm.NonceSize()), contentKey, nil))
+	}
+
+	var hdr bytes.Buffer
+	writeEnvField(&hdr, envHdrRecips, field.Bytes())
+	p.writeField(&hdr, envHdrPolicy)
+	return sealEnvelope(contentKey, hdr.Bytes(), payload)
+}
+
+// OpenForRecipient decrypts an envelope written by SealForRecipients with one
+// of the recipients' private keys. It returns ErrRecipientsOnly when priv is
+// not listed and ErrAuth when the data was altered.
+func OpenForRecipient(data []byte, priv *ecdh.PrivateKey) ([]byte, error) {
+	if len(data) < 1 || data[0] != EncMagic {
+		return nil, ErrNotEncrypted
+	}
+	env, err := parseEnvelope(data)
+	if err != nil {
+		return nil, err
+	}
+	if env.recipients == nil {
+		return nil, ErrAuth
+	}
+	pub := priv.PublicKey()
+	id := KeyID(pub.Bytes())
+	for _, r := range env.recipients {
+		if !bytes.Equal(r.id, id) {
+			continue
+		}
+		ephemeral, err := ParsePublicKey(env.ephemeral)
+		if err != nil {
+			return nil, errEnvelope
+		}
+		shared, err := priv.ECDH(ephemeral)
+		if err != nil {
+			return nil, ErrAuth
+		}
+		kek, err := recipientKEK(shared, env.ephemeral, pub.Bytes())
+		if err != nil {
+			return nil, err
+		}
+		gcm, err := newGCM(kek)
+		if err != nil {
+			return nil, err
+		}
+		contentKey, err := gcm.Open(nil, make([]byte, gcm.NonceSize()), r.wrapped, nil)
+		if err != nil {
+			return nil, ErrAuth
+		}
+		return openGCM(contentKey, env.ad, env.sealed)
+	}
+	return nil, ErrRecipientsOnly
+}
+
+// ForRecipients reports whether data is an envelope written by
+// SealForRecipients.
+func ForRecipients(data []byte) bool {
+	env, err := parseEnvelope(data)
+	return err == nil && env.recipients != nil
+}
+
+// recipientKEK derives the key that wraps the content key for one recipient
+// from the X25519 shared secret, which the sender computes from the ephemeral
+// private key and the recipient from its own.
+func recipientKEK(shared, ephemeral, recipient []byte) ([]byte, error) {
+	salt := append(append([]byte{}, ephemeral...), recipient...)
+	return hkdf.Key(sha256.New, shared, salt, recipientInfo, KeySize)
+}
+
+// parseRecipients reads an ENV_RECIPIENTS field into env.
+func (env *envelope) parseRecipients(field []byte) error {
+	const entrySize = keyIDSize + wrappedKeySize
+	if len(field) <= KeySize || (len(field)-KeySize)%entrySize != 0 {
+		return errEnvelope
+	}
+	env.ephemeral = field[:KeySize]
+	for pos := KeySize; pos < len(field); pos += entrySize {
+		env.recipients = append(env.recipients, wrappedKey{
+			id:      field[pos : pos+keyIDSize],
+			wrapped: field[pos+keyIDSize : pos+entrySize],
+		})
+	}
+	return nil
+}
+
+// newGCM returns AES-256-GCM under key.
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
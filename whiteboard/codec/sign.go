@@ -0,0 +1,52 @@
+package codec
+
+import (
+	"bytes"
+	"crypto/ed25519"
+)
+
+// sigContext separates whiteboard signatures from any other use of the same
+// Ed25519 key (Ed25519ctx, RFC 8032).
+const sigContext = "whiteboard payload"
+
+// Signature is the author signature carried in a payload trailer. The public
+// key comes from the payload itself, so Valid only proves the board is as that
+// key signed it; compare Fingerprint with the author's to know who that is.
+type Signature struct {
+	PublicKey ed25519.PublicKey
+	Valid     bool // false when the payload was changed after signing
+}
+
+// Fingerprint returns the signer fingerprint in the form of Fingerprint.
+func (s *Signature) Fingerprint() string {
+	return Fingerprint(s.PublicKey)
+}
+
+// EncodeSigned is EncodeWithHeader with a TRL_SIGNATURE trailer made with
+// priv, so recipients can tell who drew the board and whether it was altered.
+func EncodeSigned(h Header, cmds []Cmd, priv ed25519.PrivateKey) ([]byte, error) {
+	raw := encodeRaw(h, cmds)
+	sig, err := priv.Sign(nil, raw.Bytes(), &ed25519.Options{Context: sigContext})
+	if err != nil {
+		return nil, err
+	}
+	var field bytes.Buffer
+	field.Write(priv.Public().(ed25519.PublicKey))
+	field.Write(sig)
+	raw.WriteByte(vecTrlSig)
+	writeUvarint(raw, uint64(field.Len()))
+	raw.Write(field.Bytes())
+	return compress(raw.Bytes()), nil
+}
+
+// verifySignature checks a TRL_SIGNATURE field starting at start against the
+// payload bytes in front of it.
+func verifySignature(payload []byte, start int, field []byte) (*Signature, error) {
+	if len(field) != ed25519.PublicKeySize+ed25519.SignatureSize {
+		return nil, &TruncatedError{What: "trailer", Offset: start}
+	}
+	pub := ed25519.PublicKey(field[:ed25519.PublicKeySize])
+	err := ed25519.VerifyWithOptions(pub, payload[:start], field[ed25519.PublicKeySize:],
+		&ed25519.Options{Context: sigContext})
+	return &Signature{PublicKey: bytes.Clone(pub), Valid: err == nil}, nil
+}
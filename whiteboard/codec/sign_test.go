@@ -0,0 +1,61 @@
+package codec
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"reflect"
+	"testing"
+)
+
+func TestEncodeSigned(t *testing.T) {
+	priv := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{7}, ed25519.SeedSize))
+	pub := priv.Public().(ed25519.PublicKey)
+	signed, err := EncodeSigned(Header{Width: 64, Height: 64, Bg: White}, board, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := inflate(signed, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The red channel of the first stroke, just past the header and the
+	// fill command.
+	red := bytes.Index(raw, []byte{vecTagStroke, 255, 0, 0, 4})
+	if red < 0 {
+		t.Fatal("first stroke not found")
+	}
+	altered := bytes.Clone(raw)
+	altered[red+1] = 254
+	appended := append(bytes.Clone(raw), vecTrlBranches, 2, 0, 0)
+
+	tests := []struct {
+		name    string
+		payload []byte
+		valid   bool
+		err     error
+	}{
+		{"valid", raw, true, nil},
+		{"altered body", altered, false, nil},
+		{"appended after signature", appended, false, &TruncatedError{What: "trailer", Offset: len(raw)}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, hdr, err := DefaultLimits.Decode(deflate(tt.payload))
+			if !reflect.DeepEqual(err, tt.err) {
+				t.Fatalf("Decode error = %v, want %v", err, tt.err)
+			}
+			if err != nil {
+				return
+			}
+			if hdr.Signature == nil {
+				t.Fatal("no signature")
+			}
+			if hdr.Signature.Valid != tt.valid {
+				t.Errorf("Valid = %v, want %v", hdr.Signature.Valid, tt.valid)
+			}
+			if !hdr.Signature.PublicKey.Equal(pub) {
+				t.Error("signature carries another public key")
+			}
+		})
+	}
+}
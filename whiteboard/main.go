@@ -1,72 +1,205 @@
+//go:build js && wasm
+
 package main
 
 import (
 	"bytes"
-	"compress/flate"
-	"crypto/aes"
-	"crypto/cipher"
-	"crypto/rand"
-	"crypto/sha256"
+	"crypto/ecdh"
+	"crypto/ed25519"
 	"encoding/base64"
-	"encoding/binary"
+	"encoding/xml"
 	"errors"
+	"fmt"
 	"image"
 	"image/color"
-	"io"
+	"image/draw"
+	"image/png"
+	"slices"
+	"strings"
 	"syscall/js"
+	"time"
+
+	"github.com/raydac/bkbin2wav/codec"
+	"github.com/raydac/bkbin2wav/render"
+	"github.com/raydac/bkbin2wav/svgimport"
 )
 
-// ── Vector format constants ──────────────────────────────────────────────────
-// Wire format (uncompressed payload, then FLATE level-9 compressed):
-//   Header     : magic 'V' (1) | version 0x01 (1) | cmdCount uint16LE
-//   CMD_STROKE (0x01): tag(1) | R G B W(4) | pointCount uint16LE
-//                    | x0 uint16LE | y0 uint16LE  (first point, absolute)
-//                    | dx int16LE  | dy int16LE   (repeated pointCount-1)
-//   CMD_CLEAR  (0x02): tag(1)        - no payload
-//   CMD_FILL   (0x03): tag(1) | R G B (3)
-//
-// Encryption envelope (replaces old "ENC:" string prefix):
-//   Encrypted URL  : base64url( 0x45 'E' | AES-256-GCM(FLATE_payload) )
-//   Unencrypted URL: base64url( FLATE_payload )   <- starts with 0x78 (FLATE)
-// Detection: first raw byte == 0x45 -> encrypted; else -> try FLATE -> legacy.
+// The share format (vector command log, legacy bitmap, encryption envelope)
+// lives in package codec and rasterization in package render; this file owns
+// the canvas, input and history. imgData is the authoritative picture: every
+// committed command is rendered into it by package render and copied onto the
+// canvas, which Canvas2D draws on directly only for live stroke feedback.
+
+var vecCmds []codec.Cmd        // full undo/redo history (all commands ever committed)
+var historyPos int             // number of commands currently applied; undo/redo moves this
+var vecCurStroke *codec.Stroke // stroke currently being built (not yet committed)
+
+// branches holds the other lines of the undo tree: every line drawn and then
+// left by drawing after an undo, each a complete command list from the start.
+// Lines share the commands in front of the point where they fork.
+var branches [][]codec.Cmd
 
+// Branches are kept in memory and in every autosave, so they are capped: at
+// most maxBranches lines, whose commands past the fork with the current line
+// stay within branchBudget points (a clear or fill counts as one). Past either
+// limit the oldest branches go first.
 const (
-	vecMagic     = byte('V')
-	vecVersion   = byte(0x01)
-	vecTagStroke = byte(0x01)
-	vecTagClear  = byte(0x02)
-	vecTagFill   = byte(0x03)
-	encMagic     = byte('E') // 0x45 - flags an encrypted payload
+	maxBranches  = 32
+	branchBudget = 1 << 18
 )
 
-type vecCmd interface{ isVecCmd() }
+// Undo and redo redraw imgData from a raster checkpoint, or from the last
+// clear or fill, rather than from the first command. A checkpoint is taken
+// every checkpointEvery commands, and checkpoints together stay within
+// checkpointBudget bytes; past that the ones farthest from historyPos go.
+const (
+	checkpointEvery  = 50
+	checkpointBudget = 32 << 20
+)
 
-type vecCmdStroke struct {
-	r, g, b    byte
-	width      byte
-	pts        [][2]int16 // pts[0] = absolute (x,y); pts[1..] = int16 deltas
-	absX, absY int
+// checkpoint holds the pixels of imgData with vecCmds[:pos] applied.
+type checkpoint struct {
+	pos int
+	pix []byte
 }
 
-func (v *vecCmdStroke) isVecCmd() {}
+var checkpoints []checkpoint // ordered by pos
 
-type vecCmdClear struct{}
+// historyPush appends cmd at historyPos. A new action clears the redo stack:
+// the commands ahead of the cursor stay reachable as a branch. imgData must
+// show vecCmds[:historyPos] when it is called, as it may be checkpointed.
+func historyPush(cmd codec.Cmd) {
+	dropCheckpoints(historyPos)
+	saveCheckpoint(historyPos)
+	if historyPos < len(vecCmds) {
+		branches = append(branches, vecCmds)
+		vecCmds = vecCmds[:historyPos:historyPos] // the branch keeps the array
+		trimBranches()
+	}
+	if s, ok := cmd.(*codec.Stroke); ok && group > 0 {
+		s.Joined = true
+	}
+	if group >= 0 {
+		group++
+	}
+	vecCmds = append(vecCmds, cmd)
+	historyPos++
+	scheduleAutosave()
+}
 
-func (v vecCmdClear) isVecCmd() {}
+// An undo step is usually one command, but a gesture can push several: a
+// freehand line that leaves and re-enters the canvas, a chain of right-click
+// lines, an SVG import. Strokes pushed between beginGroup and endGroup after
+// the first command are marked Joined, and undo and redo move over a joined
+// stroke together with the command before it. The mark is part of the stroke,
+// so shared and saved boards keep their undo steps.
+var group = -1 // commands pushed since beginGroup; -1 outside a group
 
-type vecCmdFill struct{ r, g, b byte }
+// beginGroup starts a new undo step that the following pushes join.
+func beginGroup() { group = 0 }
 
-func (v vecCmdFill) isVecCmd() {}
+// endGroup closes the undo step begun by beginGroup, if any.
+func endGroup() { group = -1 }
 
-var vecCmds []vecCmd           // full undo/redo history (all commands ever committed)
-var historyPos int             // number of commands currently applied; undo/redo moves this
-var vecCurStroke *vecCmdStroke // stroke currently being built (not yet committed)
+// isJoined reports whether cmd belongs to the undo step of the command before.
+func isJoined(cmd codec.Cmd) bool {
+	s, ok := cmd.(*codec.Stroke)
+	return ok && s.Joined
+}
 
-// historyPush appends cmd at historyPos, discarding any redo-able commands ahead
-// of the cursor (new action always clears the redo stack).
-func historyPush(cmd vecCmd) {
-	vecCmds = append(vecCmds[:historyPos], cmd)
-	historyPos++
+// groupStart returns pos, or, when pos falls inside a group of joined
+// commands, the position where the undo step holding it starts.
+func groupStart(cmds []codec.Cmd, pos int) int {
+	for pos > 0 && pos < len(cmds) && isJoined(cmds[pos]) {
+		pos--
+	}
+	return pos
+}
+
+// trimBranches drops the oldest branches until they are within maxBranches
+// and branchBudget.
+func trimBranches() {
+	sizes := make([]int, len(branches))
+	total := 0
+	for i, line := range branches {
+		for _, cmd := range line[forkPoint(vecCmds, line):] {
+			sizes[i]++
+			if s, ok := cmd.(*codec.Stroke); ok {
+				sizes[i] += len(s.Pts)
+			}
+		}
+		total += sizes[i]
+	}
+	drop := 0
+	for drop < len(branches) && (len(branches)-drop > maxBranches || total > branchBudget) {
+		total -= sizes[drop]
+		drop++
+	}
+	if drop > 0 {
+		branches = append([][]codec.Cmd(nil), branches[drop:]...)
+	}
+}
+
+// forkPoint returns the number of leading commands lines a and b share.
+func forkPoint(a, b []codec.Cmd) int {
+	n := 0
+	for n < len(a) && n < len(b) && a[n] == b[n] {
+		n++
+	}
+	return n
+}
+
+// saveCheckpoint records imgData as the picture at pos when pos is due one:
+// a multiple of checkpointEvery with no clear or fill in the commands just
+// before it, which would be as quick to start from.
+func saveCheckpoint(pos int) {
+	if pos == 0 || pos%checkpointEvery != 0 {
+		return
+	}
+	for _, cmd := range vecCmds[pos-checkpointEvery : pos] {
+		switch cmd.(type) {
+		case codec.Clear, codec.Fill:
+			return
+		}
+	}
+	i := 0
+	for i < len(checkpoints) && checkpoints[i].pos < pos {
+		i++
+	}
+	if i < len(checkpoints) && checkpoints[i].pos == pos {
+		return
+	}
+	limit := checkpointBudget / len(imgData.Pix)
+	if limit == 0 {
+		return
+	}
+	pix := make([]byte, len(imgData.Pix))
+	copy(pix, imgData.Pix)
+	checkpoints = slices.Insert(checkpoints, i, checkpoint{pos, pix})
+	for len(checkpoints) > limit {
+		far := 0
+		for j, c := range checkpoints {
+			if abs(c.pos-historyPos) > abs(checkpoints[far].pos-historyPos) {
+				far = j
+			}
+		}
+		checkpoints = slices.Delete(checkpoints, far, far+1)
+	}
+}
+
+// dropCheckpoints forgets the checkpoints past pos, whose commands are about
+// to be replaced.
+func dropCheckpoints(pos int) {
+	for len(checkpoints) > 0 && checkpoints[len(checkpoints)-1].pos > pos {
+		checkpoints = checkpoints[:len(checkpoints)-1]
+	}
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
 }
 
 func vecStartStroke(x, y int) {
@@ -78,45 +211,44 @@ func vecStartStroke(x, y int) {
 	if w < 1 {
 		w = 1
 	}
-	vecCurStroke = &vecCmdStroke{
-		r: penColor.R, g: penColor.G, b: penColor.B,
-		width: byte(w), absX: x, absY: y,
+	vecCurStroke = &codec.Stroke{
+		R: penColor.R, G: penColor.G, B: penColor.B,
+		Width: byte(w),
 	}
-	vecCurStroke.pts = append(vecCurStroke.pts, [2]int16{int16(x), int16(y)})
+	vecCurStroke.Pts = append(vecCurStroke.Pts, [2]int{x, y})
+	vecCurStroke.Times = append(vecCurStroke.Times, time.Now().UnixMilli())
 }
 
 func vecAddPoint(x, y int) {
 	if vecCurStroke == nil {
 		return
 	}
-	dx := x - vecCurStroke.absX
-	dy := y - vecCurStroke.absY
+	last := vecCurStroke.Pts[len(vecCurStroke.Pts)-1]
 	// Skip duplicate positions — mouse can fire many events without moving.
-	if dx == 0 && dy == 0 {
+	if last == [2]int{x, y} {
 		return
 	}
-	if dx > 32767 {
-		dx = 32767
-	}
-	if dx < -32768 {
-		dx = -32768
-	}
-	if dy > 32767 {
-		dy = 32767
+	if n := codec.DefaultLimits.MaxStrokePoints; n > 1 && len(vecCurStroke.Pts) >= n {
+		// Links refuse longer strokes: go on in a new one from the last point,
+		// which joins the undo step of the gesture.
+		vecStartStroke(last[0], last[1])
 	}
-	if dy < -32768 {
-		dy = -32768
-	}
-	vecCurStroke.pts = append(vecCurStroke.pts, [2]int16{int16(dx), int16(dy)})
-	vecCurStroke.absX, vecCurStroke.absY = x, y
+	vecCurStroke.Pts = append(vecCurStroke.Pts, [2]int{x, y})
+	vecCurStroke.Times = append(vecCurStroke.Times, time.Now().UnixMilli())
 }
 
 func vecEndStroke() {
-	if vecCurStroke == nil || len(vecCurStroke.pts) == 0 {
+	// Every edit, undo and export starts here, so playback ends first and the
+	// history position is the user's own again.
+	stopPlayback()
+	if vecCurStroke == nil || len(vecCurStroke.Pts) == 0 {
 		vecCurStroke = nil
 		return
 	}
 	historyPush(vecCurStroke)
+	// The live segments were drawn by Canvas2D for feedback; replace them with
+	// the Go rendering so the canvas shows exactly what imgData holds.
+	flushImageData(render.Stroke(imgData, vecCurStroke))
 	vecCurStroke = nil
 }
 
@@ -129,6 +261,7 @@ var (
 	penWidth                  int        = 2
 	imgData                   *image.RGBA
 	canvasWidth, canvasHeight int
+	canvasBg                  color.RGBA = codec.White // colour CMD_CLEAR restores
 )
 
 func main() {
@@ -149,9 +282,6 @@ func main() {
 	}
 
 	imgData = image.NewRGBA(image.Rect(0, 0, canvasWidth, canvasHeight))
-	for i := range imgData.Pix {
-		imgData.Pix[i] = 255
-	}
 
 	// Parse pen width from URL params
 	if urlParams.Call("has", "pen").Bool() {
@@ -167,8 +297,7 @@ func main() {
 	canvas.Set("height", canvasHeight)
 	ctx = canvas.Call("getContext", "2d")
 
-	ctx.Set("fillStyle", "white")
-	ctx.Call("fillRect", 0, 0, canvasWidth, canvasHeight)
+	paintBackground(canvasBg)
 
 	loadFromURL()
 
@@ -185,15 +314,58 @@ func main() {
 	js.Global().Set("setColor", js.FuncOf(setColor))
 	js.Global().Set("setWidth", js.FuncOf(setWidth))
 	js.Global().Set("exportImage", js.FuncOf(exportImage))
+	js.Global().Set("exportSVG", js.FuncOf(exportSVG))
+	js.Global().Set("exportPDF", js.FuncOf(exportPDF))
+	js.Global().Set("exportPNG", js.FuncOf(exportPNG))
+	js.Global().Set("exportGIF", js.FuncOf(exportGIF))
+	js.Global().Set("importSVG", js.FuncOf(importSVG))
 	js.Global().Set("tryLoadWithPassword", js.FuncOf(tryLoadWithPassword))
 	js.Global().Set("clearCanvas", js.FuncOf(clearCanvas))
 	js.Global().Set("fillCanvas", js.FuncOf(fillCanvas))
 	js.Global().Set("loadImageData", js.FuncOf(loadImageDataJS))
+	js.Global().Set("loadImageDataWithKey", js.FuncOf(loadImageDataWithKeyJS))
+	js.Global().Set("getLoadError", js.FuncOf(getLoadErrorJS))
+	js.Global().Set("isEncryptedData", js.FuncOf(isEncryptedDataJS))
+	js.Global().Set("setDecodeLimits", js.FuncOf(setDecodeLimitsJS))
+	js.Global().Set("generateKeyPair", js.FuncOf(generateKeyPairJS))
+	js.Global().Set("getKeyPair", js.FuncOf(getKeyPairJS))
+	js.Global().Set("exportPrivateKey", js.FuncOf(exportPrivateKeyJS))
+	js.Global().Set("importPrivateKey", js.FuncOf(importPrivateKeyJS))
+	js.Global().Set("deleteKeyPair", js.FuncOf(deleteKeyPairJS))
+	js.Global().Set("keyFingerprint", js.FuncOf(keyFingerprintJS))
+	js.Global().Set("getSigningKey", js.FuncOf(getSigningKeyJS))
+	js.Global().Set("getSignature", js.FuncOf(getSignatureJS))
+	js.Global().Set("getLinkPolicy", js.FuncOf(getLinkPolicyJS))
 	js.Global().Set("resizeCanvas", js.FuncOf(resizeCanvasJS))
 	js.Global().Set("undoCanvas", js.FuncOf(undoJS))
 	js.Global().Set("redoCanvas", js.FuncOf(redoJS))
 	js.Global().Set("canUndoCanvas", js.FuncOf(canUndoJS))
 	js.Global().Set("canRedoCanvas", js.FuncOf(canRedoJS))
+	js.Global().Set("getHistory", js.FuncOf(getHistoryJS))
+	js.Global().Set("getBranches", js.FuncOf(getBranchesJS))
+	js.Global().Set("switchBranch", js.FuncOf(switchBranchJS))
+	js.Global().Set("playHistory", js.FuncOf(playHistoryJS))
+	js.Global().Set("pausePlayback", js.FuncOf(pausePlaybackJS))
+	js.Global().Set("seekPlayback", js.FuncOf(seekPlaybackJS))
+	js.Global().Set("stopPlayback", js.FuncOf(stopPlaybackJS))
+	js.Global().Set("getPlayback", js.FuncOf(getPlaybackJS))
+	playbackTick = js.FuncOf(playbackFrame)
+	js.Global().Set("getAutosave", js.FuncOf(getAutosaveJS))
+	js.Global().Set("restoreAutosave", js.FuncOf(restoreAutosaveJS))
+	js.Global().Set("discardAutosave", js.FuncOf(discardAutosaveJS))
+	js.Global().Set("getAutosaveError", js.FuncOf(getAutosaveErrorJS))
+	autosaveTick = js.FuncOf(func(this js.Value, args []js.Value) interface{} {
+		autosave()
+		return nil
+	})
+	js.Global().Call("addEventListener", "pagehide", js.FuncOf(flushAutosave))
+
+	// Offer the board saved before the last reload or crash, unless the page
+	// was opened to show a shared one.
+	if img, _ := urlImgParams(); img == "" && (ownAutosave.has() || sharedAutosave.has()) {
+		ownSaveOffered = ownAutosave.has()
+		js.Global().Call("eval", "if(typeof onAutosaveFound !== 'undefined') onAutosaveFound();")
+	}
 
 	select {}
 }
@@ -246,6 +418,9 @@ func canvasCoords(clientX, clientY int, rect js.Value) (int, int) {
 }
 
 func mouseDown(this js.Value, args []js.Value) interface{} {
+	if linkPolicy.ReadOnly {
+		return nil // view-only link: nothing is recorded
+	}
 	e := args[0]
 	if e.Get("button").Int() == 2 {
 		// Right-click: do nothing here. The browser also fires contextmenu after
@@ -253,10 +428,13 @@ func mouseDown(this js.Value, args []js.Value) interface{} {
 		// here we would overwrite the anchor before contextMenu can use it.
 		return nil
 	}
-	// Left click: normal freehand drawing.
+	// Left click: normal freehand drawing. The segments the gesture is split
+	// into when it leaves the canvas undo as one.
 	rect := canvas.Call("getBoundingClientRect")
 	lastX, lastY = canvasCoords(e.Get("clientX").Int(), e.Get("clientY").Int(), rect)
 	drawing = true
+	vecEndStroke()
+	beginGroup()
 	vecStartStroke(lastX, lastY)
 	drawPoint(lastX, lastY)
 	return nil
@@ -277,6 +455,9 @@ func mouseMove(this js.Value, args []js.Value) interface{} {
 
 func mouseUp(this js.Value, args []js.Value) interface{} {
 	vecEndStroke()
+	if drawing {
+		endGroup()
+	}
 	drawing = false
 	return nil
 }
@@ -319,29 +500,37 @@ func mouseEnter(this js.Value, args []js.Value) interface{} {
 
 // contextMenu fires on right-click. Draws a straight line from the last known
 // position (lastX, lastY) to the click point, then updates lastX/lastY to that
-// point so subsequent right-clicks chain lines. Suppresses the browser menu.
+// point so subsequent right-clicks chain lines; a chain is one undo step, up
+// to the next freehand gesture or other action. Suppresses the browser menu.
 func contextMenu(this js.Value, args []js.Value) interface{} {
 	e := args[0]
 	e.Call("preventDefault")
+	if linkPolicy.ReadOnly {
+		return nil
+	}
 	rect := canvas.Call("getBoundingClientRect")
 	x, y := canvasCoords(e.Get("clientX").Int(), e.Get("clientY").Int(), rect)
 	// End any in-progress freehand stroke cleanly before drawing the line.
 	vecEndStroke()
+	if group < 0 {
+		beginGroup()
+	}
 	// Record and draw the straight line as a two-point stroke.
+	// vecEndStroke renders it.
 	vecStartStroke(lastX, lastY)
 	vecAddPoint(x, y)
 	vecEndStroke()
-	drawLine(lastX, lastY, x, y)
 	lastX, lastY = x, y
 	return nil
 }
 
+// drawPoint and drawLine give live feedback while the mouse is down. They only
+// touch the canvas; vecEndStroke renders the finished stroke into imgData.
 func drawPoint(x, y int) {
 	ctx.Set("fillStyle", colorToHex(penColor))
 	ctx.Call("beginPath")
 	ctx.Call("arc", x, y, penWidth/2, 0, 2*3.14159)
 	ctx.Call("fill")
-	updateImageData(x, y)
 }
 
 func drawLine(x0, y0, x1, y1 int) {
@@ -352,62 +541,35 @@ func drawLine(x0, y0, x1, y1 int) {
 	ctx.Call("moveTo", x0, y0)
 	ctx.Call("lineTo", x1, y1)
 	ctx.Call("stroke")
-	updateImageDataLine(x0, y0, x1, y1)
-}
-
-func updateImageData(x, y int) {
-	r := penWidth / 2
-	for dy := -r; dy <= r; dy++ {
-		for dx := -r; dx <= r; dx++ {
-			if dx*dx+dy*dy <= r*r {
-				px, py := x+dx, y+dy
-				if px >= 0 && px < canvasWidth && py >= 0 && py < canvasHeight {
-					idx := py*imgData.Stride + px*4
-					imgData.Pix[idx] = penColor.R
-					imgData.Pix[idx+1] = penColor.G
-					imgData.Pix[idx+2] = penColor.B
-					imgData.Pix[idx+3] = penColor.A
-				}
-			}
-		}
-	}
 }
 
-func updateImageDataLine(x0, y0, x1, y1 int) {
-	dx := abs(x1 - x0)
-	dy := abs(y1 - y0)
-	sx := -1
-	if x0 < x1 {
-		sx = 1
-	}
-	sy := -1
-	if y0 < y1 {
-		sy = 1
-	}
-	err := dx - dy
+// paintBackground fills the whole canvas and imgData with c. Used for the
+// initial canvas, CMD_CLEAR (with canvasBg) and CMD_FILL.
+func paintBackground(c color.RGBA) {
+	ctx.Set("fillStyle", colorToHex(c))
+	ctx.Call("fillRect", 0, 0, canvasWidth, canvasHeight)
+	render.Fill(imgData, c)
+}
 
-	for {
-		updateImageData(x0, y0)
-		if x0 == x1 && y0 == y1 {
-			break
-		}
-		e2 := 2 * err
-		if e2 > -dy {
-			err -= dy
-			x0 += sx
-		}
-		if e2 < dx {
-			err += dx
-			y0 += sy
-		}
-	}
+// flushImageData copies rectangle r of imgData, which is authoritative, onto
+// the canvas.
+func flushImageData(r image.Rectangle) {
+	putImage(imgData, r)
 }
 
-func abs(x int) int {
-	if x < 0 {
-		return -x
+// putImage copies rectangle r of img onto the canvas.
+func putImage(img *image.RGBA, r image.Rectangle) {
+	r = r.Intersect(img.Bounds())
+	if r.Empty() {
+		return
+	}
+	pix := make([]byte, 0, r.Dx()*r.Dy()*4)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		pix = append(pix, img.Pix[img.PixOffset(r.Min.X, y):img.PixOffset(r.Max.X, y)]...)
 	}
-	return x
+	imgJSData := ctx.Call("createImageData", r.Dx(), r.Dy())
+	js.CopyBytesToJS(imgJSData.Get("data"), pix)
+	ctx.Call("putImageData", imgJSData, r.Min.X, r.Min.Y)
 }
 
 func setColor(this js.Value, args []js.Value) interface{} {
@@ -424,30 +586,24 @@ func setWidth(this js.Value, args []js.Value) interface{} {
 }
 
 func clearCanvas(this js.Value, args []js.Value) interface{} {
-	vecEndStroke()
-	historyPush(vecCmdClear{})
-	ctx.Set("fillStyle", "white")
-	ctx.Call("fillRect", 0, 0, canvasWidth, canvasHeight)
-	for i := range imgData.Pix {
-		imgData.Pix[i] = 255
+	if linkPolicy.ReadOnly {
+		return nil
 	}
+	vecEndStroke()
+	endGroup()
+	historyPush(codec.Clear{})
+	paintBackground(canvasBg)
 	return nil
 }
 
 func fillCanvas(this js.Value, args []js.Value) interface{} {
-	vecEndStroke()
-	historyPush(vecCmdFill{r: penColor.R, g: penColor.G, b: penColor.B})
-	ctx.Set("fillStyle", colorToHex(penColor))
-	ctx.Call("fillRect", 0, 0, canvasWidth, canvasHeight)
-	for y := 0; y < canvasHeight; y++ {
-		for x := 0; x < canvasWidth; x++ {
-			idx := y*imgData.Stride + x*4
-			imgData.Pix[idx] = penColor.R
-			imgData.Pix[idx+1] = penColor.G
-			imgData.Pix[idx+2] = penColor.B
-			imgData.Pix[idx+3] = penColor.A
-		}
+	if linkPolicy.ReadOnly {
+		return nil
 	}
+	vecEndStroke()
+	endGroup()
+	historyPush(codec.Fill{R: penColor.R, G: penColor.G, B: penColor.B})
+	paintBackground(penColor)
 	return nil
 }
 
@@ -459,390 +615,479 @@ func colorToHex(c color.RGBA) string {
 		string(hexDigits[c.B>>4]) + string(hexDigits[c.B&0xf])
 }
 
+// exportImage returns the share payload for the current history as base64url.
+// It takes an optional password and an optional options object; with
+// {secretLink: true} the payload is sealed under a fresh random key instead and
+// the result is {img, key}, both base64url, for a link that carries the key in
+// its #fragment. With {recipients: [publicKey, ...]} (base64url X25519 keys)
+// only those recipients can open it. {sign: true} adds an Ed25519 signature
+// made with this browser's signing key. {readOnly: true} and {expiresIn:
+// seconds} restrict the link; a board opened from a restricted link keeps its
+// restrictions when shared again. {timing: true} keeps the capture times of
+// strokes so replays follow the drawing pace; boards with timing need this
+// version of the whiteboard to open. {branches: true} adds the other lines of
+// the undo tree, the redo stack included, which older versions ignore.
+// {fullHistory: true} sends every command, from the first one to the end of
+// the redo stack, with the undo position, so the recipient can undo and redo
+// through the author's work; older versions draw the redo stack too. {step: n}
+// exports the board as of history position n instead of the current one, and
+// {fromStep: m} only the commands after position m, drawn on an empty board; a
+// position inside a multi-part gesture counts from where the gesture starts.
+// It returns "" on failure, with the reason in lastLoadErr for a negative step,
+// a recipient key that does not parse or a signing key that cannot be stored.
 func exportImage(this js.Value, args []js.Value) interface{} {
+	lastLoadErr = nil
 	password := ""
 	if len(args) > 0 && !args[0].IsNull() && !args[0].IsUndefined() {
 		password = args[0].String()
 	}
+	secretLink, sign, timing, withBranches, fullHistory := false, false, false, false, false
+	step, fromStep := -1, 0
+	var recipients []*ecdh.PublicKey
+	policy := linkPolicy
+	if len(args) > 1 && args[1].Type() == js.TypeObject {
+		secretLink = args[1].Get("secretLink").Truthy()
+		sign = args[1].Get("sign").Truthy()
+		timing = args[1].Get("timing").Truthy()
+		withBranches = args[1].Get("branches").Truthy()
+		fullHistory = args[1].Get("fullHistory").Truthy()
+		if v := args[1].Get("step"); v.Type() == js.TypeNumber {
+			if step = v.Int(); step < 0 {
+				lastLoadErr = errHistoryStep
+				return ""
+			}
+		}
+		if v := args[1].Get("fromStep"); v.Type() == js.TypeNumber {
+			if fromStep = v.Int(); fromStep < 0 {
+				lastLoadErr = errHistoryStep
+				return ""
+			}
+		}
+		var requested codec.Policy
+		requested.ReadOnly = args[1].Get("readOnly").Truthy()
+		if v := args[1].Get("expiresIn"); v.Type() == js.TypeNumber && v.Int() > 0 {
+			requested.Expires = time.Now().Add(time.Duration(v.Int()) * time.Second)
+		}
+		policy = policy.Merge(requested)
+		if list := args[1].Get("recipients"); list.Type() == js.TypeObject {
+			for i := 0; i < list.Length(); i++ {
+				key := list.Index(i).String()
+				pub, err := parsePublicKey(key)
+				if err != nil {
+					lastLoadErr = &recipientKeyError{Key: key, Err: err}
+					return ""
+				}
+				recipients = append(recipients, pub)
+			}
+		}
+	}
 
 	vecEndStroke() // commit any in-progress stroke
 
-	active := vecCmds[:historyPos]
+	if step < 0 {
+		step = historyPos
+	}
+	// Positions inside a joined gesture move back to where it starts, so no
+	// export splits an undo step.
+	step = groupStart(vecCmds, min(step, len(vecCmds)))
+	fromStep = groupStart(vecCmds, min(fromStep, step))
+	active, undone := vecCmds[fromStep:step], 0
+	if fullHistory {
+		active, undone = vecCmds[fromStep:], len(vecCmds)-step
+	}
 	if len(active) == 0 {
 		return ""
 	}
 
 	// Trim to the suffix after the last clear/fill: commands before a full-canvas
-	// overwrite are invisible and would only inflate the URL.
-	trimmed := trimHistory(active)
+	// overwrite are invisible and would only inflate the URL. The full history
+	// keeps them to undo through, and branches may fork before it.
+	trimmed := active
+	var alternatives []codec.Branch
+	if withBranches {
+		alternatives = historyBranches(vecCmds[:fromStep+len(active)], fromStep)
+	} else if !fullHistory {
+		trimmed = trimHistory(active)
+	}
+	if !timing {
+		trimmed = untimed(trimmed)
+		for i := range alternatives {
+			alternatives[i].Cmds = untimed(alternatives[i].Cmds)
+		}
+	}
 
 	// Serialise and FLATE-compress the command log.
-	payload := encodeVecCmds(trimmed)
+	var signer ed25519.PrivateKey
+	if sign {
+		var err error
+		if signer, err = localSigningKey(true); err != nil {
+			lastLoadErr = fmt.Errorf("%w: %w", errSigningKey, err)
+			return ""
+		}
+	}
+	encrypted := len(recipients) > 0 || secretLink || password != ""
+	var payloadPolicy codec.Policy
+	if !encrypted {
+		payloadPolicy = policy // no envelope to bind it to
+	}
+	payload, err := encodeVecCmds(codec.Header{Policy: payloadPolicy, Undone: undone, Branches: alternatives},
+		trimmed, signer)
+	if err != nil {
+		return ""
+	}
 
-	var data []byte
-	if password != "" {
-		// Encrypt the compressed payload, prepend single encMagic byte.
-		encrypted, err := encrypt(payload, password)
+	if len(recipients) > 0 {
+		sealed, err := policy.SealForRecipients(payload, recipients)
 		if err != nil {
 			return ""
 		}
-		data = append([]byte{encMagic}, encrypted...)
-	} else {
-		data = payload
+		return base64.RawURLEncoding.EncodeToString(sealed)
 	}
 
-	return base64.RawURLEncoding.EncodeToString(data)
-}
+	if secretLink {
+		key, err := codec.NewKey()
+		if err != nil {
+			return ""
+		}
+		sealed, err := policy.SealWithKey(payload, key)
+		if err != nil {
+			return ""
+		}
+		return map[string]interface{}{
+			"img": base64.RawURLEncoding.EncodeToString(sealed),
+			"key": base64.RawURLEncoding.EncodeToString(key),
+		}
+	}
 
-// writeDelta writes a signed delta using a compact variable-length scheme:
-//
-//	|d| <= 126  →  1 byte: bits[6:0] = abs(d), bit7 = sign (0=positive, 1=negative)
-//	|d| >  126  →  3 bytes: 0xFF marker + int16 LE
-//
-// Typical mouse deltas are ±1–10 pixels, so ~99% of deltas cost 1 byte instead of 2.
-func writeDelta(buf *bytes.Buffer, d int16) {
-	ad := d
-	if ad < 0 {
-		ad = -ad
-	}
-	if ad <= 126 {
-		b := byte(ad)
-		if d < 0 {
-			b |= 0x80
+	data := payload
+	if password != "" {
+		// Encrypt the compressed payload into a salted PBKDF2 envelope.
+		sealed, err := policy.Seal(payload, password)
+		if err != nil {
+			return ""
 		}
-		buf.WriteByte(b)
-	} else {
-		buf.WriteByte(0xFF)
-		binary.Write(buf, binary.LittleEndian, d)
+		data = sealed
 	}
+
+	return base64.RawURLEncoding.EncodeToString(data)
 }
 
-// rdpEpsilon is the perpendicular-distance threshold (in pixels) below which
-// intermediate points are considered collinear and removed. 1.0 px is lossless
-// at any pen width >= 2 px (the removed points fall inside the stroke anyway).
-const rdpEpsilon = 1.0
+// exportSVG returns the current history as an SVG document: strokes become
+// round-capped <path> elements and clears and fills full-canvas rects. Like
+// exportImage it drops everything before the last clear or fill. It returns ""
+// if rendering fails, with the reason in lastLoadErr.
+func exportSVG(this js.Value, args []js.Value) interface{} {
+	vecEndStroke() // commit any in-progress stroke
 
-// rdpSimplify applies the Ramer-Douglas-Peucker algorithm to a slice of
-// absolute-coordinate points, returning a simplified version.
-func rdpSimplify(pts [][2]int, lo, hi int, out *[][2]int) {
-	if hi <= lo+1 {
-		return
-	}
-	// Find the point with max perpendicular distance from line lo→hi.
-	ax, ay := float64(pts[lo][0]), float64(pts[lo][1])
-	bx, by := float64(pts[hi][0]), float64(pts[hi][1])
-	dx, dy := bx-ax, by-ay
-	lineLenSq := dx*dx + dy*dy
-	maxDist, maxIdx := 0.0, lo+1
-	for i := lo + 1; i < hi; i++ {
-		px, py := float64(pts[i][0]), float64(pts[i][1])
-		var dist float64
-		if lineLenSq == 0 {
-			dist = (px-ax)*(px-ax) + (py-ay)*(py-ay)
-			dist = sqrt64(dist)
-		} else {
-			// Perpendicular distance = |cross product| / line length
-			cross := (px-ax)*dy - (py-ay)*dx
-			if cross < 0 {
-				cross = -cross
-			}
-			dist = cross / sqrt64(lineLenSq)
-		}
-		if dist > maxDist {
-			maxDist, maxIdx = dist, i
-		}
+	var cmds []codec.Cmd
+	if historyPos > 0 {
+		cmds = trimHistory(vecCmds[:historyPos])
 	}
-	if maxDist > rdpEpsilon {
-		rdpSimplify(pts, lo, maxIdx, out)
-		*out = append(*out, pts[maxIdx])
-		rdpSimplify(pts, maxIdx, hi, out)
+	var buf strings.Builder
+	if err := render.SVG(&buf, canvasWidth, canvasHeight, canvasBg, cmds); err != nil {
+		lastLoadErr = err
+		return ""
 	}
+	lastLoadErr = nil
+	return buf.String()
 }
 
-// sqrt64 is a simple integer square root approximation using Newton's method.
-func sqrt64(x float64) float64 {
-	if x <= 0 {
-		return 0
+// exportPNG returns imgData as a PNG file (a Uint8Array) that also carries the
+// share payload of the history in a private chunk, so loadImageData restores
+// the editable board from it; viewers just show the picture. It returns null
+// if encoding fails, with the reason in lastLoadErr.
+func exportPNG(this js.Value, args []js.Value) interface{} {
+	vecEndStroke() // commit any in-progress stroke
+
+	var cmds []codec.Cmd
+	if historyPos > 0 {
+		cmds = trimHistory(vecCmds[:historyPos])
 	}
-	z := x / 2
-	for i := 0; i < 8; i++ {
-		z -= (z*z - x) / (2 * z)
+	payload, err := encodeVecCmds(codec.Header{Policy: linkPolicy}, cmds, nil)
+	if err != nil {
+		lastLoadErr = err
+		return nil
 	}
-	return z
-}
-
-// simplifyStkPts decodes the delta-encoded pts of a vecCmdStroke into absolute
-// coords, runs RDP simplification, and returns the simplified absolute points.
-func simplifyStkPts(s *vecCmdStroke) [][2]int {
-	abspts := make([][2]int, len(s.pts))
-	abspts[0] = [2]int{int(s.pts[0][0]), int(s.pts[0][1])}
-	for i := 1; i < len(s.pts); i++ {
-		abspts[i][0] = abspts[i-1][0] + int(s.pts[i][0])
-		abspts[i][1] = abspts[i-1][1] + int(s.pts[i][1])
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, imgData); err != nil {
+		lastLoadErr = err
+		return nil
 	}
-	if len(abspts) <= 2 {
-		return abspts
+	file, err := codec.EmbedInPNG(buf.Bytes(), payload)
+	if err != nil {
+		lastLoadErr = err
+		return nil
 	}
-	out := [][2]int{abspts[0]}
-	rdpSimplify(abspts, 0, len(abspts)-1, &out)
-	out = append(out, abspts[len(abspts)-1])
+	lastLoadErr = nil
+	out := js.Global().Get("Uint8Array").New(len(file))
+	js.CopyBytesToJS(out, file)
 	return out
 }
 
-// readDelta decodes one variable-length delta component from payload at pos.
-// Returns (delta value, bytes consumed). Returns (0, 0) on truncation.
-// Mirrors the writeDelta encoding exactly.
-func readDelta(payload []byte, pos int) (int, int) {
-	if pos >= len(payload) {
-		return 0, 0
-	}
-	b := payload[pos]
-	if b == 0xFF {
-		if pos+3 > len(payload) {
-			return 0, 0
-		}
-		d := int(int16(binary.LittleEndian.Uint16(payload[pos+1 : pos+3])))
-		return d, 3
-	}
-	// 1-byte form: bit7=sign, bits[6:0]=magnitude
-	mag := int(b & 0x7F)
-	if b&0x80 != 0 {
-		mag = -mag
-	}
-	return mag, 1
-}
-
-// encodeVecCmds serialises the command log into the binary wire format and
-// FLATE-compresses it.
-//
-// Compression techniques applied:
-//  1. RDP simplification  — removes near-collinear points per stroke (lossless at 1px epsilon)
-//  2. Variable-length deltas — 1 byte for |delta|<=126 (covers ~99% of mouse move steps),
-//     3 bytes for larger deltas, vs fixed 2 bytes previously
-//  3. FLATE level-9        — compresses the already-compact binary further
-func encodeVecCmds(cmds []vecCmd) []byte {
-	var raw bytes.Buffer
-	raw.WriteByte(vecMagic)
-	raw.WriteByte(vecVersion)
-	binary.Write(&raw, binary.LittleEndian, uint16(len(cmds)))
-
-	for _, cmd := range cmds {
-		switch c := cmd.(type) {
-		case *vecCmdStroke:
-			raw.WriteByte(vecTagStroke)
-			raw.WriteByte(c.r)
-			raw.WriteByte(c.g)
-			raw.WriteByte(c.b)
-			raw.WriteByte(c.width)
-			// Simplify points with RDP before encoding.
-			simplified := simplifyStkPts(c)
-			binary.Write(&raw, binary.LittleEndian, uint16(len(simplified)))
-			// First point: absolute coords as uint16.
-			binary.Write(&raw, binary.LittleEndian, uint16(simplified[0][0]))
-			binary.Write(&raw, binary.LittleEndian, uint16(simplified[0][1]))
-			// Subsequent points: variable-length signed deltas.
-			for i := 1; i < len(simplified); i++ {
-				writeDelta(&raw, int16(simplified[i][0]-simplified[i-1][0]))
-				writeDelta(&raw, int16(simplified[i][1]-simplified[i-1][1]))
+// exportGIF returns an animated GIF (a Uint8Array) replaying the history up to
+// the undo position, clears included, the way it was drawn. It takes an
+// optional object: {delay: ms per frame, maxFrames: n, pointsPerFrame: n,
+// speed: x} where pointsPerFrame > 0 draws strokes progressively and speed > 0
+// follows the recorded drawing pace at that multiple instead; see
+// render.Timelapse for the defaults. It returns null on failure, with the
+// reason in lastLoadErr; a speed outside render.MinSpeed to render.MaxSpeed is
+// one.
+func exportGIF(this js.Value, args []js.Value) interface{} {
+	vecEndStroke() // commit any in-progress stroke
+
+	var opt render.TimelapseOptions
+	if len(args) > 0 && args[0].Type() == js.TypeObject {
+		get := func(name string) int {
+			if v := args[0].Get(name); v.Type() == js.TypeNumber {
+				return v.Int()
 			}
-		case vecCmdClear:
-			raw.WriteByte(vecTagClear)
-		case vecCmdFill:
-			raw.WriteByte(vecTagFill)
-			raw.WriteByte(c.r)
-			raw.WriteByte(c.g)
-			raw.WriteByte(c.b)
+			return 0
+		}
+		opt.Delay = (get("delay") + 5) / 10
+		opt.MaxFrames = get("maxFrames")
+		opt.PointsPerFrame = get("pointsPerFrame")
+		if v := args[0].Get("speed"); v.Type() == js.TypeNumber {
+			opt.Speed = v.Float()
 		}
 	}
-
-	var out bytes.Buffer
-	flw, _ := flate.NewWriter(&out, 9)
-	flw.Write(raw.Bytes())
-	flw.Close()
-	return out.Bytes()
-}
-
-func compressPlane(data []byte) []byte {
-	// Apply RLE first for runs of identical bytes
-	rleData := runLengthEncode(data)
-
-	// Then compress with FLATE
 	var buf bytes.Buffer
-	// FLATE compression with level 9 (best compression)
-	flw, _ := flate.NewWriter(&buf, 9)
-	flw.Write(rleData)
-	flw.Close()
-	return buf.Bytes()
+	if err := render.Timelapse(&buf, canvasWidth, canvasHeight, canvasBg, vecCmds[:historyPos], opt); err != nil {
+		lastLoadErr = err
+		return nil
+	}
+	lastLoadErr = nil
+	out := js.Global().Get("Uint8Array").New(buf.Len())
+	js.CopyBytesToJS(out, buf.Bytes())
+	return out
 }
 
-func decompressPlane(data []byte) ([]byte, error) {
-	// Decompress FLATE first
-	flr := flate.NewReader(bytes.NewReader(data))
-	defer flr.Close()
+// exportPDF returns the current history as a one-page PDF document (a
+// Uint8Array) the size of the canvas, drawn with vector path operators like
+// exportSVG. It returns null on failure, with the reason in lastLoadErr.
+func exportPDF(this js.Value, args []js.Value) interface{} {
+	vecEndStroke() // commit any in-progress stroke
 
+	var cmds []codec.Cmd
+	if historyPos > 0 {
+		cmds = trimHistory(vecCmds[:historyPos])
+	}
 	var buf bytes.Buffer
-	if _, err := io.Copy(&buf, flr); err != nil {
-		return nil, err
+	if err := render.PDF(&buf, canvasWidth, canvasHeight, canvasBg, cmds); err != nil {
+		lastLoadErr = err
+		return nil
 	}
-
-	// Then decode RLE
-	return runLengthDecode(buf.Bytes()), nil
+	lastLoadErr = nil
+	out := js.Global().Get("Uint8Array").New(buf.Len())
+	js.CopyBytesToJS(out, buf.Bytes())
+	return out
 }
 
-func runLengthEncode(data []byte) []byte {
-	if len(data) == 0 {
-		return data
+// importSVG draws the shapes of an SVG document (a string) onto the board, one
+// stroke per subpath, scaled down to fit when the document is larger than the
+// canvas. The strokes go through historyPush like hand-drawn ones, so they are
+// shared with the board, and undo as a single step. It returns the number of strokes
+// added, or -1 with getLoadError explaining why the document was refused.
+func importSVG(this js.Value, args []js.Value) interface{} {
+	vecEndStroke()
+	lastLoadErr = nil
+	if linkPolicy.ReadOnly {
+		lastLoadErr = errReadOnly
+		return -1
 	}
+	if len(args) == 0 || args[0].Type() != js.TypeString {
+		lastLoadErr = svgimport.ErrNotSVG
+		return -1
+	}
+	strokes, err := svgimport.Import(strings.NewReader(args[0].String()),
+		svgimport.Options{Width: canvasWidth, Height: canvasHeight})
+	if err != nil {
+		lastLoadErr = err
+		return -1
+	}
+	var dirty image.Rectangle
+	beginGroup()
+	for _, s := range strokes {
+		historyPush(s)
+		dirty = dirty.Union(render.Stroke(imgData, s))
+	}
+	endGroup()
+	flushImageData(dirty)
+	return len(strokes)
+}
 
-	var result bytes.Buffer
-	i := 0
-
-	for i < len(data) {
-		currentByte := data[i]
-		runLength := 1
-
-		// Count consecutive identical bytes (max 255)
-		for i+1 < len(data) && data[i+1] == currentByte && runLength < 255 {
-			i++
-			runLength++
+// untimed returns cmds with the capture times of strokes left out, so they
+// encode as plain CMD_STROKE.
+func untimed(cmds []codec.Cmd) []codec.Cmd {
+	out := make([]codec.Cmd, len(cmds))
+	for i, cmd := range cmds {
+		if s, ok := cmd.(*codec.Stroke); ok && s.Times != nil {
+			plain := *s
+			plain.Times = nil
+			cmd = &plain
 		}
+		out[i] = cmd
+	}
+	return out
+}
 
-		if runLength >= 3 {
-			// Use RLE for runs of 3 or more: [marker=255][byte][count]
-			result.WriteByte(255) // RLE marker
-			result.WriteByte(currentByte)
-			result.WriteByte(byte(runLength))
-		} else {
-			// For short runs, write literally
-			for j := 0; j < runLength; j++ {
-				// If byte is 255 (marker), escape it
-				if currentByte == 255 {
-					result.WriteByte(255)
-					result.WriteByte(255)
-					result.WriteByte(1) // Run of 1
-				} else {
-					result.WriteByte(currentByte)
-				}
-			}
+// historyBranches returns every line of the undo tree other than cmds, the
+// redo stack included, as branches of cmds[from:]; lines that fork before from
+// are left out.
+func historyBranches(cmds []codec.Cmd, from int) []codec.Branch {
+	var out []codec.Branch
+	for _, line := range append([][]codec.Cmd{vecCmds}, branches...) {
+		if fork := forkPoint(cmds, line); fork >= from && fork < len(line) {
+			out = append(out, codec.Branch{Fork: fork - from, Cmds: line[fork:]})
 		}
-
-		i++
 	}
-
-	return result.Bytes()
+	return out
 }
 
-func runLengthDecode(data []byte) []byte {
-	if len(data) == 0 {
-		return data
+// encodeVecCmds serialises the command log with hdr, completed with the current
+// canvas size and background, signed by signer unless it is nil; see
+// codec.EncodeWithHeader for the wire format.
+func encodeVecCmds(hdr codec.Header, cmds []codec.Cmd, signer ed25519.PrivateKey) ([]byte, error) {
+	hdr.Width, hdr.Height, hdr.Bg = canvasWidth, canvasHeight, canvasBg
+	if signer != nil {
+		return codec.EncodeSigned(hdr, cmds, signer)
 	}
+	return codec.EncodeWithHeader(hdr, cmds), nil
+}
 
-	var result bytes.Buffer
-	i := 0
+// errCanvasSize is reported when a payload asks for a canvas size resizeCanvas
+// refuses.
+var errCanvasSize = errors.New("canvas size out of range")
 
-	for i < len(data) {
-		if data[i] == 255 && i+2 < len(data) {
-			// RLE sequence: [255][byte][count]
-			byteVal := data[i+1]
-			count := int(data[i+2])
-			for j := 0; j < count; j++ {
-				result.WriteByte(byteVal)
-			}
-			i += 3
-		} else {
-			// Literal byte
-			result.WriteByte(data[i])
-			i++
-		}
-	}
+// errReadOnly is reported when something tries to draw on a view-only board.
+var errReadOnly = errors.New("board is view-only")
 
-	return result.Bytes()
-}
+// errNoAutosave is reported when there is no saved board to restore.
+var errNoAutosave = errors.New("no saved board")
 
-func encrypt(data []byte, password string) ([]byte, error) {
-	// Use PBKDF2-like key derivation (SHA-256 is stable and well-tested)
-	key := sha256.Sum256([]byte(password))
+// errHistoryStep is reported when an export asks for a negative history step.
+var errHistoryStep = errors.New("history step out of range")
 
-	// AES-256-GCM is a NIST-approved, stable encryption algorithm
-	block, err := aes.NewCipher(key[:])
-	if err != nil {
-		return nil, err
-	}
+// recipientKeyError is reported when an export is given a recipient public key
+// that does not parse, such as a stale or hand-edited stored key.
+type recipientKeyError struct {
+	Key string
+	Err error
+}
 
-	// GCM provides authenticated encryption (prevents tampering)
-	gcm, err := cipher.NewGCM(block)
-	if err != nil {
-		return nil, err
-	}
+func (e *recipientKeyError) Error() string {
+	return fmt.Sprintf("recipient key %q: %v", e.Key, e.Err)
+}
 
-	// Generate cryptographically secure random nonce
-	nonce := make([]byte, gcm.NonceSize())
-	if _, err := rand.Read(nonce); err != nil {
-		return nil, err
-	}
+func (e *recipientKeyError) Unwrap() error { return e.Err }
 
-	// Encrypt and authenticate: nonce || ciphertext || auth_tag
-	ciphertext := gcm.Seal(nonce, nonce, data, nil)
-	return ciphertext, nil
-}
+// lastLoadErr is the error of the most recent failed load or export;
+// getLoadError exposes it to JS so the page can explain a failure instead of a
+// bare false or null.
+var lastLoadErr error
 
-func decrypt(data []byte, password string) ([]byte, error) {
-	// Derive same key from password
-	key := sha256.Sum256([]byte(password))
+// linkPolicy holds the restrictions of the board loaded last: read-only stops
+// the input handlers from recording anything, and exports carry it along.
+var linkPolicy codec.Policy
 
-	block, err := aes.NewCipher(key[:])
-	if err != nil {
-		return nil, err
-	}
+// lastSignature is the author signature of the board loaded last, nil when it
+// was unsigned; getSignature exposes the verification result to JS.
+var lastSignature *codec.Signature
 
-	gcm, err := cipher.NewGCM(block)
+// decodeImgParam decodes the base64 img value (URL-safe without padding, as
+// produced by exportImage, or standard base64 from older links).
+func decodeImgParam(data string) ([]byte, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(data)
 	if err != nil {
-		return nil, err
+		decoded, err = base64.StdEncoding.DecodeString(data)
 	}
+	return decoded, err
+}
 
-	nonceSize := gcm.NonceSize()
-	if len(data) < nonceSize {
-		return nil, errors.New("invalid ciphertext")
-	}
+// urlImgParams returns the img value of the page URL and the secret link key.
+// img is read from the query string, or from the #fragment where secret links
+// keep it so the ciphertext never reaches the server; k only ever lives in the
+// fragment.
+func urlImgParams() (img, key string) {
+	location := js.Global().Get("location")
+	URLSearchParams := js.Global().Get("URLSearchParams")
+	query := URLSearchParams.New(location.Get("search"))
+	fragment := URLSearchParams.New(strings.TrimPrefix(location.Get("hash").String(), "#"))
+	if query.Call("has", "img").Bool() {
+		img = query.Call("get", "img").String()
+	} else if fragment.Call("has", "img").Bool() {
+		img = fragment.Call("get", "img").String()
+	}
+	if fragment.Call("has", "k").Bool() {
+		key = fragment.Call("get", "k").String()
+	}
+	return img, key
+}
 
-	// Extract nonce and ciphertext
-	nonce, ciphertext := data[:nonceSize], data[nonceSize:]
+// needsPassword reports whether a share payload is password protected, as
+// opposed to plain, a secret link or sealed to public keys.
+func needsPassword(data []byte) bool {
+	return codec.IsEncrypted(data) && !codec.NeedsKey(data) && !codec.ForRecipients(data)
+}
 
-	// Decrypt and verify authentication tag
-	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
-	if err != nil {
-		return nil, err
+// openImageData loads a share payload that needs no password: a plain payload,
+// a secret link opened with its base64url linkKey, or a board sealed to public
+// keys opened with the local key pair. The error is also kept in lastLoadErr.
+func openImageData(decoded []byte, linkKey string) error {
+	var keys codec.Keys
+	var err error
+	if linkKey != "" {
+		keys.LinkKey, err = decodeImgParam(linkKey)
+	}
+	if err == nil && codec.ForRecipients(decoded) {
+		if keys.PrivateKey = localPrivateKey(); keys.PrivateKey == nil {
+			err = errNoKeyPair
+		}
 	}
-
-	return plaintext, nil
+	if err == nil {
+		var plain []byte
+		var policy codec.Policy
+		if plain, policy, err = keys.Unseal(decoded); err == nil {
+			err = loadImageData(plain, policy)
+		}
+	}
+	if err == nil {
+		boardAutosave = &sharedAutosave
+		if codec.IsEncrypted(decoded) {
+			boardAutosave = nil
+		}
+	}
+	lastLoadErr = err
+	return err
 }
 
 func loadFromURL() {
-	urlParams := js.Global().Get("URLSearchParams").New(js.Global().Get("location").Get("search"))
-	if !urlParams.Call("has", "img").Bool() {
-		return
-	}
-	data := urlParams.Call("get", "img").String()
+	data, key := urlImgParams()
 	if data == "" {
 		return
 	}
-	decoded, err := base64.RawURLEncoding.DecodeString(data)
+	decoded, err := decodeImgParam(data)
 	if err != nil {
-		decoded, err = base64.StdEncoding.DecodeString(data)
-		if err != nil {
-			js.Global().Call("alert", "Failed to decode image data: Invalid base64 format")
+		lastLoadErr = err
+		js.Global().Call("alert", "Failed to decode image data: Invalid base64 format")
+		return
+	}
+	// EncMagic envelope (or legacy "ENC:" prefix) under a password - show
+	// password modal. Secret links and boards sealed to this device's key open
+	// without a prompt.
+	if needsPassword(decoded) {
+		// An expired link fails without asking for a password first.
+		if err := codec.EnvelopePolicy(decoded).Check(time.Now()); err != nil {
+			lastLoadErr = err
+			_, msg, _ := describeLoadError(err)
+			js.Global().Call("alert", "Failed to load image: "+msg)
 			return
 		}
-	}
-	// First byte == encMagic means AES-GCM encrypted - show password modal.
-	// Also handle legacy "ENC:" prefix for old URLs.
-	if (len(decoded) >= 1 && decoded[0] == encMagic) ||
-		(len(decoded) >= 4 && string(decoded[:4]) == "ENC:") {
 		js.Global().Call("eval", "if(typeof passwordModalInstance !== 'undefined') passwordModalInstance.show();")
 		return
 	}
-	if !loadImageData(decoded) {
-		js.Global().Call("alert", "Failed to load image: Invalid image data format")
+	if err := openImageData(decoded, key); err != nil {
+		_, msg, _ := describeLoadError(err)
+		js.Global().Call("alert", "Failed to load image: "+msg)
 	}
 }
 
@@ -852,296 +1097,765 @@ func tryLoadWithPassword(this js.Value, args []js.Value) interface{} {
 	}
 	password := args[0].String()
 
-	urlParams := js.Global().Get("URLSearchParams").New(js.Global().Get("location").Get("search"))
-	if !urlParams.Call("has", "img").Bool() {
-		js.Global().Call("alert", "No image data found in URL")
-		return false
-	}
-	data := urlParams.Call("get", "img").String()
+	data, _ := urlImgParams()
 	if data == "" {
-		js.Global().Call("alert", "Image data is empty")
+		js.Global().Call("alert", "No image data found in URL")
 		return false
 	}
-	decoded, err := base64.RawURLEncoding.DecodeString(data)
+	decoded, err := decodeImgParam(data)
 	if err != nil {
-		decoded, err = base64.StdEncoding.DecodeString(data)
-		if err != nil {
-			js.Global().Call("alert", "Failed to decode image data")
-			return false
-		}
+		lastLoadErr = err
+		js.Global().Call("alert", "Failed to decode image data")
+		return false
 	}
 
-	// Strip the encryption marker byte (new format: encMagic; legacy: "ENC:").
-	var ciphertext []byte
-	if len(decoded) >= 1 && decoded[0] == encMagic {
-		ciphertext = decoded[1:]
-	} else if len(decoded) >= 4 && string(decoded[:4]) == "ENC:" {
-		ciphertext = decoded[4:] // legacy back-compat
-	} else {
+	if !codec.IsEncrypted(decoded) {
+		lastLoadErr = codec.ErrNotEncrypted
 		js.Global().Call("alert", "Image is not encrypted")
 		return false
 	}
 
-	decrypted, err := decrypt(ciphertext, password)
+	decrypted, policy, err := codec.Keys{Password: password}.Unseal(decoded)
 	if err != nil {
+		lastLoadErr = err
 		return false // wrong password - modal shows its own error
 	}
-	if loadImageData(decrypted) {
-		js.Global().Call("eval", "if(typeof passwordModalInstance !== 'undefined') passwordModalInstance.hide();")
-		return true
+	if err := loadImageData(decrypted, policy); err != nil {
+		_, msg, _ := describeLoadError(err)
+		js.Global().Call("alert", "Failed to load decrypted image data: "+msg)
+		return false
 	}
-	js.Global().Call("alert", "Failed to load decrypted image data")
-	return false
+	boardAutosave = nil
+	js.Global().Call("eval", "if(typeof passwordModalInstance !== 'undefined') passwordModalInstance.hide();")
+	return true
 }
 
-// loadImageData dispatches on format.
-// New vector format: the raw bytes are a FLATE stream; decompressed payload
-//
-//	starts with vecMagic 'V' + vecVersion 0x01.
-//
-// Legacy bitmap: raw bytes start with a uint16 offsetX header (not a FLATE stream).
+// loadImageData decodes a (decrypted) share payload and shows it: vector
+// payloads are replayed into an editable history, anything that is not a
+// vector FLATE stream is tried as a legacy bitmap. The error is also kept in
+// lastLoadErr for getLoadError.
 // After decryption the plaintext is passed here directly, so we never see
-// encMagic here - that byte is consumed by tryLoadWithPassword/loadFromURL.
-func loadImageData(data []byte) bool {
-	if len(data) == 0 {
-		return false
+// EncMagic here - that byte is consumed by tryLoadWithPassword/loadFromURL.
+// The signature check happens in codec.Load; a mismatch does not stop the
+// board from loading, it is reported through getSignature and the page's
+// onSignatureChecked hook. envPolicy is the (authenticated) policy of the
+// envelope data came in; with the payload's own policy it decides whether the
+// link has expired and whether the board opens read-only.
+func loadImageData(data []byte, envPolicy codec.Policy) error {
+	lastLoadErr = nil
+	flushPendingAutosave() // the board about to be replaced goes to its own slot
+	img, err := codec.Load(data)
+	var policy codec.Policy
+	if err == nil {
+		policy = envPolicy.Merge(img.Header.Policy)
+		err = policy.Check(time.Now())
+	}
+	if err == nil {
+		if img.Legacy != nil {
+			loadLegacyBitmapData(img.Legacy)
+		} else {
+			err = replayVecCmds(img.Cmds, img.Header)
+		}
 	}
-
-	// Try FLATE decompression to detect new vector format.
-	flr := flate.NewReader(bytes.NewReader(data))
-	var raw bytes.Buffer
-	_, flateErr := io.Copy(&raw, flr)
-	flr.Close()
-
-	if flateErr == nil && raw.Len() >= 2 &&
-		raw.Bytes()[0] == vecMagic && raw.Bytes()[1] == vecVersion {
-		return replayVecCmds(raw.Bytes())
+	lastLoadErr = err
+	if err == nil {
+		// The restrictions belong to the board now shown; a failed load keeps
+		// those of the board still on the canvas.
+		linkPolicy = policy
+		js.Global().Call("eval", "if(typeof onPolicyApplied !== 'undefined') onPolicyApplied();")
+		lastSignature = img.Header.Signature
+		js.Global().Call("eval", "if(typeof onSignatureChecked !== 'undefined') onSignatureChecked();")
 	}
-	// Fall through to legacy bitmap decoder.
-	return loadLegacyBitmapData(data)
+	return err
 }
 
-// replayVecCmds decodes the uncompressed vector payload, replays all commands
-// onto the canvas, and rebuilds vecCmds so the user can keep drawing.
-func replayVecCmds(payload []byte) bool {
-	if len(payload) < 4 {
-		return false
-	}
-	cmdCount := int(binary.LittleEndian.Uint16(payload[2:4]))
-	pos := 4
-
-	ctx.Set("fillStyle", "white")
-	ctx.Call("fillRect", 0, 0, canvasWidth, canvasHeight)
-	for i := range imgData.Pix {
-		imgData.Pix[i] = 255
+// replayVecCmds resizes the canvas to the size carried in hdr (payloads without
+// one replay onto the current size), draws the decoded commands up to the undo
+// position hdr carries, and rebuilds vecCmds and its branches so the user can
+// keep drawing, undo and redo.
+func replayVecCmds(cmds []codec.Cmd, hdr codec.Header) error {
+	dropPlayback() // the history it plays is about to be replaced
+	if hdr.Width > 0 && hdr.Height > 0 &&
+		(hdr.Width != canvasWidth || hdr.Height != canvasHeight) {
+		if !resizeCanvas(hdr.Width, hdr.Height) {
+			return fmt.Errorf("%w: %dx%d", errCanvasSize, hdr.Width, hdr.Height)
+		}
+		js.Global().Call("eval", "if(typeof onCanvasResized !== 'undefined') onCanvasResized();")
+	}
+	canvasBg = hdr.Bg
+	vecCmds = append([]codec.Cmd(nil), cmds...)
+	historyPos = len(vecCmds) - hdr.Undone
+	branches = nil
+	for _, b := range hdr.Branches {
+		if len(b.Cmds) > 0 {
+			branches = append(branches, append(vecCmds[:b.Fork:b.Fork], b.Cmds...))
+		}
 	}
-	vecCmds = nil
-	historyPos = 0
+	trimBranches()
 	vecCurStroke = nil
+	checkpoints = nil
+	endGroup()
+	applyHistoryAt(historyPos)
+	return nil
+}
 
-	for i := 0; i < cmdCount; i++ {
-		if pos >= len(payload) {
-			return false
+// loadLegacyBitmapData shows a bitmap payload from URLs generated before the
+// vector format was introduced.
+func loadLegacyBitmapData(bm *codec.LegacyBitmap) {
+	// The bitmap replaces the board, so the history of the old one goes.
+	dropPlayback()
+	vecCmds, historyPos = nil, 0
+	branches = nil
+	vecCurStroke = nil
+	checkpoints = nil
+	endGroup()
+
+	// Legacy bitmaps predate the canvas header and always sit on white.
+	canvasBg = codec.White
+	paintBackground(canvasBg)
+	draw.Draw(imgData, bm.Bounds(), bm, bm.Bounds().Min, draw.Src)
+	flushImageData(imgData.Bounds())
+}
+
+// describeLoadError maps a load failure to a stable code for scripts, a
+// message for people and, where known, the byte offset of the problem (-1
+// otherwise).
+func describeLoadError(err error) (code, message string, offset int) {
+	var (
+		truncErr  *codec.TruncatedError
+		tagErr    *codec.UnknownTagError
+		verErr    *codec.VersionError
+		flateErr  *codec.FlateError
+		limitErr  *codec.LimitError
+		rangeErr  *codec.RangeError
+		expErr    *codec.ExpiredError
+		base64Err base64.CorruptInputError
+		xmlErr    *xml.SyntaxError
+		keyErr    *recipientKeyError
+	)
+	switch {
+	case errors.As(err, &truncErr):
+		return "truncated", fmt.Sprintf("the image data is incomplete (%s cut off at byte %d); "+
+			"the link was probably not copied in full", truncErr.What, truncErr.Offset), truncErr.Offset
+	case errors.As(err, &tagErr):
+		return "unknown-tag", fmt.Sprintf("the image data contains an unknown command 0x%02X at byte %d; "+
+			"it may have been made by a newer version of the whiteboard", tagErr.Tag, tagErr.Offset), tagErr.Offset
+	case errors.As(err, &verErr):
+		return "unsupported-version", fmt.Sprintf("the image uses format version %d, "+
+			"which needs a newer version of the whiteboard", verErr.Version), -1
+	case errors.As(err, &limitErr):
+		return "limit", fmt.Sprintf("the image is too large to open safely (more than %d %s)",
+			limitErr.Limit, limitErr.What), -1
+	case errors.As(err, &rangeErr):
+		return "canvas-size", fmt.Sprintf("the image asks for an unsupported %s of %d (must be %d to %d)",
+			rangeErr.What, rangeErr.Value, rangeErr.Min, rangeErr.Max), -1
+	case errors.As(err, &flateErr):
+		return "flate", "the image data could not be decompressed; " +
+			"the link was probably cut short or altered", -1
+	case errors.Is(err, codec.ErrAuth):
+		return "auth", "incorrect password, or the encrypted data was altered", -1
+	case errors.Is(err, codec.ErrNeedsPassword):
+		return "needs-password", "the image is password protected", -1
+	case errors.Is(err, codec.ErrNeedsKey):
+		return "needs-key", "this private link is missing its key (the part after #k=); " +
+			"ask for the complete link", -1
+	case errors.Is(err, codec.ErrRecipientsOnly):
+		return "recipients-only", "the image is encrypted for other people; " +
+			"your key (" + localKeyFingerprint() + ") is not among its recipients", -1
+	case errors.Is(err, errNoKeyPair):
+		return "no-key-pair", "the image is encrypted for specific people and this browser has no key pair; " +
+			"restore your private key under Keys", -1
+	case errors.As(err, &expErr):
+		return "expired", "this link expired on " + js.Global().Get("Date").New(expErr.Expires.UnixMilli()).
+			Call("toLocaleString").String(), -1
+	case errors.Is(err, codec.ErrNotEncrypted):
+		return "not-encrypted", "the image is not encrypted", -1
+	case errors.Is(err, codec.ErrNotVector):
+		return "not-image", "the data is not a whiteboard image", -1
+	case errors.Is(err, codec.ErrNoPNGPayload):
+		return "no-png-payload", "the PNG was not saved by the whiteboard, so it holds no drawing to edit", -1
+	case errors.Is(err, svgimport.ErrNotSVG):
+		return "not-svg", "the file is not an SVG drawing", -1
+	case errors.As(err, &xmlErr):
+		return "svg-syntax", fmt.Sprintf("the SVG file is malformed at line %d", xmlErr.Line), -1
+	case errors.Is(err, errReadOnly):
+		return "read-only", "this board is view-only", -1
+	case errors.Is(err, errNoAutosave):
+		return "no-autosave", "there is no saved board to restore", -1
+	case errors.As(err, &keyErr):
+		key := keyErr.Key
+		if len(key) > 48 {
+			key = key[:48] + "…"
 		}
-		tag := payload[pos]
-		pos++
+		return "recipient-key", fmt.Sprintf("the recipient key %q is not a valid public key; "+
+			"correct or remove it under Keys", key), -1
+	case errors.Is(err, errSigningKey):
+		return "signing-key", "this browser does not allow saving a signing key, so the board cannot be signed", -1
+	case errors.Is(err, errHistoryStep):
+		return "history-step", "history steps count from 0", -1
+	case errors.Is(err, errCanvasSize):
+		return "canvas-size", "the image asks for an unsupported " + err.Error(), -1
+	case errors.As(err, &base64Err):
+		return "base64", "invalid base64 format", int(base64Err)
+	}
+	return "error", err.Error(), -1
+}
 
-		switch tag {
-		case vecTagStroke:
-			if pos+6 > len(payload) {
-				return false
-			}
-			r := payload[pos]
-			g := payload[pos+1]
-			b := payload[pos+2]
-			w := int(payload[pos+3])
-			ptCount := int(binary.LittleEndian.Uint16(payload[pos+4 : pos+6]))
-			pos += 6
-			if ptCount == 0 {
-				continue
-			}
-			// First point: absolute uint16. Subsequent: variable-length deltas.
-			if pos+4 > len(payload) {
-				return false
-			}
-			pts := make([][2]int, ptCount)
-			x := int(binary.LittleEndian.Uint16(payload[pos : pos+2]))
-			y := int(binary.LittleEndian.Uint16(payload[pos+2 : pos+4]))
-			pts[0] = [2]int{x, y}
-			pos += 4
-			for j := 1; j < ptCount; j++ {
-				dx, n := readDelta(payload, pos)
-				if n == 0 {
-					return false
-				}
-				pos += n
-				dy, n := readDelta(payload, pos)
-				if n == 0 {
-					return false
-				}
-				pos += n
-				x += dx
-				y += dy
-				pts[j] = [2]int{x, y}
-			}
+// getLoadErrorJS returns null after a successful load, otherwise an object
+// {code, message, offset, detail} describing why the last load failed.
+func getLoadErrorJS(this js.Value, args []js.Value) interface{} {
+	if lastLoadErr == nil {
+		return nil
+	}
+	code, message, offset := describeLoadError(lastLoadErr)
+	return map[string]interface{}{
+		"code":    code,
+		"message": message,
+		"offset":  offset,
+		"detail":  lastLoadErr.Error(),
+	}
+}
 
-			// Replay onto canvas.
-			hex := colorToHex(color.RGBA{r, g, b, 255})
-			if ptCount == 1 {
-				ctx.Set("fillStyle", hex)
-				ctx.Call("beginPath")
-				ctx.Call("arc", pts[0][0], pts[0][1], w/2, 0, 2*3.14159)
-				ctx.Call("fill")
-			} else {
-				ctx.Set("strokeStyle", hex)
-				ctx.Set("lineWidth", w)
-				ctx.Set("lineCap", "round")
-				ctx.Set("lineJoin", "round")
-				ctx.Call("beginPath")
-				ctx.Call("moveTo", pts[0][0], pts[0][1])
-				for _, p := range pts[1:] {
-					ctx.Call("lineTo", p[0], p[1])
-				}
-				ctx.Call("stroke")
-			}
+// setDecodeLimitsJS overrides codec.DefaultLimits for links opened afterwards.
+// It takes an object with any of maxDecompressed, maxCmds, maxPoints,
+// maxStrokePoints, maxLegacyWidth, maxLegacyHeight, maxCanvasWidth and maxCanvasHeight; 0
+// disables that limit. Canvases beyond 2048x2048 are refused regardless.
+func setDecodeLimitsJS(this js.Value, args []js.Value) interface{} {
+	if len(args) == 0 || args[0].Type() != js.TypeObject {
+		return false
+	}
+	opts := args[0]
+	set := func(name string, field *int) {
+		if v := opts.Get(name); v.Type() == js.TypeNumber && v.Int() >= 0 {
+			*field = v.Int()
+		}
+	}
+	set("maxDecompressed", &codec.DefaultLimits.MaxDecompressed)
+	set("maxCmds", &codec.DefaultLimits.MaxCmds)
+	set("maxPoints", &codec.DefaultLimits.MaxPoints)
+	set("maxStrokePoints", &codec.DefaultLimits.MaxStrokePoints)
+	set("maxLegacyWidth", &codec.DefaultLimits.MaxLegacyWidth)
+	set("maxLegacyHeight", &codec.DefaultLimits.MaxLegacyHeight)
+	set("maxCanvasWidth", &codec.DefaultLimits.MaxCanvasWidth)
+	set("maxCanvasHeight", &codec.DefaultLimits.MaxCanvasHeight)
+	return true
+}
 
-			// Rebuild vecCmds entry.
-			vs := &vecCmdStroke{r: r, g: g, b: b, width: byte(w),
-				absX: pts[ptCount-1][0], absY: pts[ptCount-1][1]}
-			vs.pts = append(vs.pts, [2]int16{int16(pts[0][0]), int16(pts[0][1])})
-			for j := 1; j < ptCount; j++ {
-				dx := pts[j][0] - pts[j-1][0]
-				dy := pts[j][1] - pts[j-1][1]
-				vs.pts = append(vs.pts, [2]int16{int16(dx), int16(dy)})
-			}
-			historyPush(vs)
+// isEncryptedDataJS reports whether a Uint8Array share payload needs a
+// password, so the import dialog can ask for one before loading. Secret links
+// and boards sealed to public keys go straight to loadImageData(WithKey).
+func isEncryptedDataJS(this js.Value, args []js.Value) interface{} {
+	if len(args) == 0 {
+		return false
+	}
+	data := make([]byte, args[0].Get("length").Int())
+	js.CopyBytesToGo(data, args[0])
+	return needsPassword(data)
+}
 
-		case vecTagClear:
-			ctx.Set("fillStyle", "white")
-			ctx.Call("fillRect", 0, 0, canvasWidth, canvasHeight)
-			for i := range imgData.Pix {
-				imgData.Pix[i] = 255
-			}
-			historyPush(vecCmdClear{})
+// loadImageDataWithKeyJS opens a secret link payload (Uint8Array) with its
+// base64url key from the #k= fragment; getLoadError explains a false result.
+func loadImageDataWithKeyJS(this js.Value, args []js.Value) interface{} {
+	if len(args) < 2 {
+		return false
+	}
+	data := make([]byte, args[0].Get("length").Int())
+	js.CopyBytesToGo(data, args[0])
+	return openImageData(data, args[1].String()) == nil
+}
 
-		case vecTagFill:
-			if pos+3 > len(payload) {
-				return false
-			}
-			r := payload[pos]
-			g := payload[pos+1]
-			b := payload[pos+2]
-			pos += 3
-			hex := colorToHex(color.RGBA{r, g, b, 255})
-			ctx.Set("fillStyle", hex)
-			ctx.Call("fillRect", 0, 0, canvasWidth, canvasHeight)
-			for i := 0; i < len(imgData.Pix); i += 4 {
-				imgData.Pix[i] = r
-				imgData.Pix[i+1] = g
-				imgData.Pix[i+2] = b
-				imgData.Pix[i+3] = 255
-			}
-			historyPush(vecCmdFill{r: r, g: g, b: b})
+// loadImageDataJS loads a share payload (Uint8Array), or a PNG file saved by
+// exportPNG, whose embedded payload restores the editable history.
+func loadImageDataJS(this js.Value, args []js.Value) interface{} {
+	if len(args) == 0 {
+		return false
+	}
+
+	// Get Uint8Array from JavaScript
+	jsArray := args[0]
+	length := jsArray.Get("length").Int()
+	data := make([]byte, length)
+	js.CopyBytesToGo(data, jsArray)
 
-		default:
-			return false // unknown tag - corrupt data
+	if codec.IsPNG(data) {
+		payload, err := codec.ExtractFromPNG(data)
+		if err != nil {
+			lastLoadErr = err
+			return false
 		}
+		data = payload
 	}
+	return openImageData(data, "") == nil
+}
 
-	// Sync imgData from canvas (Canvas2D is authoritative after replay).
-	jsID := ctx.Call("getImageData", 0, 0, canvasWidth, canvasHeight)
-	js.CopyBytesToGo(imgData.Pix, jsID.Get("data"))
-	return true
+// The history is saved to localStorage autosaveDelay ms after it last changed,
+// so a reload or a crashed tab does not lose the board. The save is the whole
+// history, branches and undo position included, encoded like a share payload.
+// The user's own board and a board opened from a link or file are saved apart,
+// so editing a shared board does not replace the user's own save.
+const autosaveDelay = 1000
+
+// autosaveSlot names the localStorage items of one saved board.
+type autosaveSlot struct {
+	key     string // base64url payload
+	timeKey string // Unix ms of the save
 }
 
-// loadLegacyBitmapData is the original decoder, kept verbatim for back-compat
-// with URLs generated before the vector format was introduced.
-func loadLegacyBitmapData(data []byte) bool {
-	buf := bytes.NewReader(data)
-	var offsetX, offsetY, width, height uint16
-	if err := binary.Read(buf, binary.LittleEndian, &offsetX); err != nil {
-		return false
+var (
+	ownAutosave    = autosaveSlot{"whiteboardAutosave", "whiteboardAutosaveTime"}
+	sharedAutosave = autosaveSlot{"whiteboardAutosaveShared", "whiteboardAutosaveSharedTime"}
+
+	// boardAutosave is the slot the board on the canvas is saved to; nil for
+	// a board that arrived encrypted, which is never stored in the clear.
+	boardAutosave = &ownAutosave
+
+	// ownSaveOffered is set while the page offers to restore the user's own
+	// saved board. Until the user restores or discards it, nothing is written
+	// over it, so a stray stroke cannot replace the board on offer.
+	ownSaveOffered bool
+)
+
+var (
+	autosaveTick  js.Func  // runs autosave from setTimeout
+	autosaveTimer js.Value // id of the pending setTimeout; undefined when none
+	autosaveErr   error    // why the last save failed; nil after one that worked
+)
+
+// scheduleAutosave saves the history autosaveDelay ms from now, replacing a
+// save already scheduled. View-only and encrypted boards are not saved.
+func scheduleAutosave() {
+	if autosaveHeld() {
+		return
 	}
-	if err := binary.Read(buf, binary.LittleEndian, &offsetY); err != nil {
-		return false
+	js.Global().Call("clearTimeout", autosaveTimer)
+	autosaveTimer = js.Global().Call("setTimeout", autosaveTick, autosaveDelay)
+}
+
+// flushAutosave runs a scheduled save at once, as the page is going away.
+func flushAutosave(this js.Value, args []js.Value) interface{} {
+	flushPendingAutosave()
+	return nil
+}
+
+// flushPendingAutosave runs a scheduled save at once, before the page goes
+// away or another board replaces the one it is for.
+func flushPendingAutosave() {
+	if autosaveTimer.IsUndefined() {
+		return
 	}
-	if err := binary.Read(buf, binary.LittleEndian, &width); err != nil {
-		return false
+	js.Global().Call("clearTimeout", autosaveTimer)
+	autosave()
+}
+
+// autosave runs writeAutosave for the timer. A failed save leaves the board
+// itself unaffected; it is logged, and the onAutosaveChanged hook tells the
+// page whenever saving starts failing or works again.
+func autosave() {
+	autosaveTimer = js.Undefined()
+	err := writeAutosave()
+	if err != nil {
+		js.Global().Get("console").Call("warn", "whiteboard: autosave failed: "+err.Error())
+	}
+	changed := (err == nil) != (autosaveErr == nil)
+	autosaveErr = err
+	if changed {
+		js.Global().Call("eval", "if(typeof onAutosaveChanged !== 'undefined') onAutosaveChanged();")
+	}
+}
+
+// getAutosaveErrorJS returns why the last save of the board failed, or null
+// when it worked.
+func getAutosaveErrorJS(this js.Value, args []js.Value) interface{} {
+	if autosaveErr == nil {
+		return nil
 	}
-	if err := binary.Read(buf, binary.LittleEndian, &height); err != nil {
+	return autosaveErr.Error()
+}
+
+// writeAutosave saves the history to boardAutosave with the restrictions of
+// the board, which loadImageData enforces again on restore, or removes the
+// save when the history is empty.
+func writeAutosave() error {
+	if autosaveHeld() {
+		return nil // a view-only or encrypted board loaded since the save was scheduled
+	}
+	pos := historyPos
+	if playback != nil {
+		pos = playback.savedPos
+	}
+	if len(vecCmds) == 0 {
+		return boardAutosave.remove()
+	}
+	hdr := codec.Header{Policy: linkPolicy, Undone: len(vecCmds) - pos, Branches: historyBranches(vecCmds, 0)}
+	payload, err := encodeVecCmds(hdr, vecCmds, nil)
+	if err != nil {
+		return err
+	}
+	if _, err := callStorage("setItem", boardAutosave.key, base64.RawURLEncoding.EncodeToString(payload)); err != nil {
+		return err
+	}
+	_, err = callStorage("setItem", boardAutosave.timeKey, time.Now().UnixMilli())
+	return err
+}
+
+// autosaveHeld reports whether the board on the canvas must not be saved: it
+// is view-only or encrypted, or its slot holds the board on offer.
+func autosaveHeld() bool {
+	return linkPolicy.ReadOnly || boardAutosave == nil || boardAutosave == &ownAutosave && ownSaveOffered
+}
+
+// remove forgets the board saved in s.
+func (s *autosaveSlot) remove() error {
+	if _, err := callStorage("removeItem", s.key); err != nil {
+		return err
+	}
+	_, err := callStorage("removeItem", s.timeKey)
+	return err
+}
+
+// callStorage calls method on localStorage. A full or disabled storage
+// throws, which is returned as the error; other panics go on.
+func callStorage(method string, args ...interface{}) (v js.Value, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			jsErr, ok := r.(js.Error)
+			if !ok {
+				panic(r)
+			}
+			err = jsErr
+		}
+	}()
+	return js.Global().Get("localStorage").Call(method, args...), nil
+}
+
+// has reports whether a board is saved in s.
+func (s *autosaveSlot) has() bool {
+	stored, err := callStorage("getItem", s.key)
+	return err == nil && stored.Type() == js.TypeString
+}
+
+// autosaveSlotArg returns the slot a JS call names: the shared board's when
+// its first argument is true, else the user's own.
+func autosaveSlotArg(args []js.Value) *autosaveSlot {
+	if len(args) > 0 && args[0].Truthy() {
+		return &sharedAutosave
+	}
+	return &ownAutosave
+}
+
+// getAutosaveJS returns {savedAt} (Unix ms, 0 when unknown) when a board is
+// saved in localStorage, else null. It takes shared: true asks about the
+// board opened from a link or file, false about the user's own.
+func getAutosaveJS(this js.Value, args []js.Value) interface{} {
+	slot := autosaveSlotArg(args)
+	if !slot.has() {
+		return nil
+	}
+	savedAt := 0
+	if t, err := callStorage("getItem", slot.timeKey); err == nil && t.Type() == js.TypeString {
+		savedAt = parseIntSafe(t.String(), 0)
+	}
+	return map[string]interface{}{"savedAt": savedAt}
+}
+
+// restoreAutosaveJS opens a saved board, chosen like getAutosaveJS, in place of
+// the current one, and goes on saving it to the same slot. It returns true on
+// success, or false with getLoadError explaining why.
+func restoreAutosaveJS(this js.Value, args []js.Value) interface{} {
+	slot := autosaveSlotArg(args)
+	stored, err := callStorage("getItem", slot.key)
+	if err != nil {
+		lastLoadErr = err
 		return false
 	}
-	compressedData := make([]byte, buf.Len())
-	if _, err := buf.Read(compressedData); err != nil {
+	if stored.Type() != js.TypeString {
+		lastLoadErr = errNoAutosave
 		return false
 	}
-	interleavedBits, err := decompressPlane(compressedData)
+	data, err := decodeImgParam(stored.String())
 	if err != nil {
+		lastLoadErr = err
 		return false
 	}
-
-	ctx.Set("fillStyle", "white")
-	ctx.Call("fillRect", 0, 0, canvasWidth, canvasHeight)
-	for i := range imgData.Pix {
-		imgData.Pix[i] = 255
-	}
-
-	bitIdx := 0
-	for y := 0; y < int(height); y++ {
-		for x := 0; x < int(width); x++ {
-			getBit := func() byte {
-				bi := bitIdx / 8
-				bp := uint(7 - (bitIdx % 8))
-				bitIdx++
-				if bi < len(interleavedBits) && (interleavedBits[bi]&(1<<bp)) != 0 {
-					return 255
-				}
-				return 0
-			}
-			r, g, b2 := getBit(), getBit(), getBit()
-			destX := int(offsetX) + x
-			destY := int(offsetY) + y
-			if destX < canvasWidth && destY < canvasHeight {
-				idx := destY*imgData.Stride + destX*4
-				imgData.Pix[idx] = r
-				imgData.Pix[idx+1] = g
-				imgData.Pix[idx+2] = b2
-				imgData.Pix[idx+3] = 255
+	if err := openImageData(data, ""); err != nil {
+		// A board from an expiring link stays expired; nothing to offer again.
+		var expErr *codec.ExpiredError
+		if errors.As(err, &expErr) {
+			slot.remove()
+			if slot == &ownAutosave {
+				ownSaveOffered = false
+				scheduleAutosave()
 			}
 		}
+		return false
+	}
+	boardAutosave = slot
+	if slot == &ownAutosave {
+		ownSaveOffered = false
 	}
-	imgJSData := ctx.Call("createImageData", canvasWidth, canvasHeight)
-	js.CopyBytesToJS(imgJSData.Get("data"), imgData.Pix)
-	ctx.Call("putImageData", imgJSData, 0, 0)
 	return true
 }
 
-func loadImageDataJS(this js.Value, args []js.Value) interface{} {
+// discardAutosaveJS forgets a saved board, chosen like getAutosaveJS. Once the
+// user's own board is discarded, what was drawn meanwhile is saved in its place.
+func discardAutosaveJS(this js.Value, args []js.Value) interface{} {
+	slot := autosaveSlotArg(args)
+	slot.remove()
+	if slot == &ownAutosave && ownSaveOffered {
+		ownSaveOffered = false
+		if !autosaveHeld() {
+			autosave()
+		}
+	}
+	return nil
+}
+
+// signingKeyStorageKey is the localStorage item holding this browser's Ed25519
+// signing key seed (base64url).
+const signingKeyStorageKey = "whiteboardSigningKey"
+
+// errSigningKey is reported when a signed export needs a new signing key and
+// storage will not keep it.
+var errSigningKey = errors.New("signing key cannot be stored")
+
+// localSigningKey returns the stored signing key. When there is none it
+// returns nil, or with create a fresh key that is stored first. The error is
+// that of storage, which may be disabled or full.
+func localSigningKey(create bool) (ed25519.PrivateKey, error) {
+	stored, err := callStorage("getItem", signingKeyStorageKey)
+	if err != nil {
+		return nil, err
+	}
+	if stored.Type() == js.TypeString {
+		seed, err := base64.RawURLEncoding.DecodeString(stored.String())
+		if err == nil && len(seed) == ed25519.SeedSize {
+			return ed25519.NewKeyFromSeed(seed), nil
+		}
+	}
+	if !create {
+		return nil, nil
+	}
+	_, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := callStorage("setItem", signingKeyStorageKey, base64.RawURLEncoding.EncodeToString(priv.Seed())); err != nil {
+		return nil, err
+	}
+	return priv, nil
+}
+
+// getSigningKeyJS returns {publicKey, fingerprint} for this browser's signing
+// key, creating the key on first use, or null when storage fails.
+func getSigningKeyJS(this js.Value, args []js.Value) interface{} {
+	priv, err := localSigningKey(true)
+	if err != nil {
+		return nil
+	}
+	pub := priv.Public().(ed25519.PublicKey)
+	return map[string]interface{}{
+		"publicKey":   base64.RawURLEncoding.EncodeToString(pub),
+		"fingerprint": codec.Fingerprint(pub),
+	}
+}
+
+// getLinkPolicyJS returns {readOnly, expires} for the board loaded last;
+// expires is in milliseconds since the epoch like Date.now(), 0 for never.
+func getLinkPolicyJS(this js.Value, args []js.Value) interface{} {
+	expires := 0
+	if !linkPolicy.Expires.IsZero() {
+		expires = int(linkPolicy.Expires.UnixMilli())
+	}
+	return map[string]interface{}{
+		"readOnly": linkPolicy.ReadOnly,
+		"expires":  expires,
+	}
+}
+
+// getSignatureJS returns null when the board loaded last was unsigned,
+// otherwise {publicKey, fingerprint, valid, own}: valid is false when the board
+// was changed after signing, own is true when this browser signed it.
+func getSignatureJS(this js.Value, args []js.Value) interface{} {
+	if lastSignature == nil {
+		return nil
+	}
+	own := false // also when the key cannot be read
+	if priv, _ := localSigningKey(false); priv != nil {
+		own = priv.Public().(ed25519.PublicKey).Equal(lastSignature.PublicKey)
+	}
+	return map[string]interface{}{
+		"publicKey":   base64.RawURLEncoding.EncodeToString(lastSignature.PublicKey),
+		"fingerprint": lastSignature.Fingerprint(),
+		"valid":       lastSignature.Valid,
+		"own":         own,
+	}
+}
+
+// privateKeyStorageKey is the localStorage item holding this browser's X25519
+// private key (base64url). It never leaves the device unless exported.
+const privateKeyStorageKey = "whiteboardPrivateKey"
+
+// errNoKeyPair is reported for a board sealed to public keys when this
+// browser has no key pair to open it with.
+var errNoKeyPair = errors.New("no key pair in this browser")
+
+// localPrivateKey returns the stored private key, or nil if there is none or
+// storage cannot be read.
+func localPrivateKey() *ecdh.PrivateKey {
+	stored, err := callStorage("getItem", privateKeyStorageKey)
+	if err != nil || stored.Type() != js.TypeString {
+		return nil
+	}
+	priv, err := parsePrivateKey(stored.String())
+	if err != nil {
+		return nil
+	}
+	return priv
+}
+
+// localKeyFingerprint returns the fingerprint of the stored key pair, or "none".
+func localKeyFingerprint() string {
+	priv := localPrivateKey()
+	if priv == nil {
+		return "none"
+	}
+	return codec.Fingerprint(priv.PublicKey().Bytes())
+}
+
+// parsePublicKey parses a base64url X25519 public key as handed out by
+// getKeyPair.
+func parsePublicKey(s string) (*ecdh.PublicKey, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, err
+	}
+	return codec.ParsePublicKey(raw)
+}
+
+// parsePrivateKey parses a base64url X25519 private key as stored by
+// storePrivateKey.
+func parsePrivateKey(s string) (*ecdh.PrivateKey, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, err
+	}
+	return codec.ParsePrivateKey(raw)
+}
+
+// keyPairInfo is the JS view of a key pair: {publicKey, fingerprint}.
+func keyPairInfo(priv *ecdh.PrivateKey) interface{} {
+	if priv == nil {
+		return nil
+	}
+	pub := priv.PublicKey().Bytes()
+	return map[string]interface{}{
+		"publicKey":   base64.RawURLEncoding.EncodeToString(pub),
+		"fingerprint": codec.Fingerprint(pub),
+	}
+}
+
+// storePrivateKey saves priv as this browser's key pair.
+func storePrivateKey(priv *ecdh.PrivateKey) error {
+	_, err := callStorage("setItem", privateKeyStorageKey, base64.RawURLEncoding.EncodeToString(priv.Bytes()))
+	return err
+}
+
+// generateKeyPairJS replaces this browser's key pair with a new one and
+// returns {publicKey, fingerprint}; boards sealed to the old key no longer open.
+// It returns null when the key cannot be stored.
+func generateKeyPairJS(this js.Value, args []js.Value) interface{} {
+	priv, err := codec.NewKeyPair()
+	if err != nil {
+		return nil
+	}
+	if err := storePrivateKey(priv); err != nil {
+		return nil
+	}
+	return keyPairInfo(priv)
+}
+
+// getKeyPairJS returns {publicKey, fingerprint} for this browser's key pair,
+// or null if none was generated or imported.
+func getKeyPairJS(this js.Value, args []js.Value) interface{} {
+	return keyPairInfo(localPrivateKey())
+}
+
+// exportPrivateKeyJS returns the stored private key as base64url for backup or
+// for moving it to another device, or "" if there is none.
+func exportPrivateKeyJS(this js.Value, args []js.Value) interface{} {
+	priv := localPrivateKey()
+	if priv == nil {
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString(priv.Bytes())
+}
+
+// importPrivateKeyJS stores a base64url private key from exportPrivateKey and
+// returns {publicKey, fingerprint}, or null if the key is invalid or cannot be
+// stored.
+func importPrivateKeyJS(this js.Value, args []js.Value) interface{} {
 	if len(args) == 0 {
-		return false
+		return nil
+	}
+	priv, err := parsePrivateKey(args[0].String())
+	if err != nil {
+		return nil
 	}
+	if err := storePrivateKey(priv); err != nil {
+		return nil
+	}
+	return keyPairInfo(priv)
+}
 
-	// Get Uint8Array from JavaScript
-	jsArray := args[0]
-	length := jsArray.Get("length").Int()
-	data := make([]byte, length)
-	js.CopyBytesToGo(data, jsArray)
+// deleteKeyPairJS forgets this browser's key pair. It returns false when
+// storage cannot be changed.
+func deleteKeyPairJS(this js.Value, args []js.Value) interface{} {
+	_, err := callStorage("removeItem", privateKeyStorageKey)
+	return err == nil
+}
 
-	return loadImageData(data)
+// keyFingerprintJS returns the fingerprint of a base64url public key, or "" if
+// it is not a valid key; the page uses it to check recipient keys.
+func keyFingerprintJS(this js.Value, args []js.Value) interface{} {
+	if len(args) == 0 {
+		return ""
+	}
+	pub, err := parsePublicKey(args[0].String())
+	if err != nil {
+		return ""
+	}
+	return codec.Fingerprint(pub.Bytes())
 }
 
+// resizeCanvasJS resizes the canvas for the page. It returns false for a
+// size out of range, or on a view-only board with lastLoadErr = errReadOnly,
+// as resizing moves every stroke of the history.
 func resizeCanvasJS(this js.Value, args []js.Value) interface{} {
+	lastLoadErr = nil
 	if len(args) < 2 {
 		return false
 	}
+	if linkPolicy.ReadOnly {
+		lastLoadErr = errReadOnly
+		return false
+	}
+	if !resizeCanvas(args[0].Int(), args[1].Int()) {
+		return false
+	}
+	scheduleAutosave()
+	return true
+}
 
-	newWidth := args[0].Int()
-	newHeight := args[1].Int()
+// resizeCanvas changes the canvas size, centering the current content (pixels
+// and vector history) in the new area. Returns false for out-of-range sizes.
+func resizeCanvas(newWidth, newHeight int) bool {
+	stopPlayback()
 
 	// Validate dimensions
 	if newWidth < 64 || newWidth > 2048 || newHeight < 64 || newHeight > 2048 {
 		return false
 	}
+	checkpoints = nil // wrong size, and the strokes move below
 
 	// Save current image data
 	oldData := make([]byte, len(imgData.Pix))
@@ -1156,18 +1870,12 @@ func resizeCanvasJS(this js.Value, args []js.Value) interface{} {
 	// Create new image data
 	imgData = image.NewRGBA(image.Rect(0, 0, canvasWidth, canvasHeight))
 
-	// Fill with white
-	for i := range imgData.Pix {
-		imgData.Pix[i] = 255
-	}
-
 	// Update canvas element
 	canvas.Set("width", canvasWidth)
 	canvas.Set("height", canvasHeight)
 
-	// Clear canvas
-	ctx.Set("fillStyle", "white")
-	ctx.Call("fillRect", 0, 0, canvasWidth, canvasHeight)
+	// Clear canvas and image data to the background colour
+	paintBackground(canvasBg)
 
 	// Center the old content in the new canvas.
 	offsetX := (canvasWidth - oldWidth) / 2
@@ -1201,116 +1909,91 @@ func resizeCanvasJS(this js.Value, args []js.Value) interface{} {
 	// Without this the vector history still references old-canvas positions, so
 	// export/replay would draw strokes at the wrong location after a resize.
 	if offsetX != 0 || offsetY != 0 {
-		shiftVecCmds(offsetX, offsetY, historyPos)
+		shiftVecCmds(offsetX, offsetY)
 	}
 
 	// Update canvas display.
-	imgJSData := ctx.Call("createImageData", canvasWidth, canvasHeight)
-	data8 := imgJSData.Get("data")
-	js.CopyBytesToJS(data8, imgData.Pix)
-	ctx.Call("putImageData", imgJSData, 0, 0)
+	flushImageData(imgData.Bounds())
 
 	return true
 }
 
-// shiftVecCmds translates all stroke coordinates in the vector history by
-// (dx, dy). Called after a resize that centers the old content, so the vector
-// record stays in sync with the visual pixel positions on the new canvas.
 // trimHistory returns the minimal suffix of cmds that produces the same visual
 // result: everything before the last CMD_CLEAR or CMD_FILL is invisible.
-func trimHistory(cmds []vecCmd) []vecCmd {
+func trimHistory(cmds []codec.Cmd) []codec.Cmd {
 	last := 0
 	for i, cmd := range cmds {
 		switch cmd.(type) {
-		case vecCmdClear, vecCmdFill:
+		case codec.Clear, codec.Fill:
 			last = i
 		}
 	}
 	// Keep from the last full-canvas overwrite onward (inclusive).
 	switch cmds[last].(type) {
-	case vecCmdClear, vecCmdFill:
+	case codec.Clear, codec.Fill:
 		return cmds[last:]
 	}
 	return cmds
 }
 
-// applyHistoryAt replays vecCmds[0:pos] onto a blank canvas.
-// Used by both undo and redo.
+// applyHistoryAt replays vecCmds[0:pos] into imgData and shows the result.
+// Used by both undo and redo. Replay starts from the nearest checkpoint or
+// clear or fill before pos and takes the checkpoints it passes.
 func applyHistoryAt(pos int) {
-	ctx.Set("fillStyle", "white")
-	ctx.Call("fillRect", 0, 0, canvasWidth, canvasHeight)
-	for i := range imgData.Pix {
-		imgData.Pix[i] = 255
-	}
-	for _, cmd := range vecCmds[:pos] {
-		switch c := cmd.(type) {
-		case *vecCmdStroke:
-			hex := colorToHex(color.RGBA{c.r, c.g, c.b, 255})
-			w := int(c.width)
-			// Reconstruct absolute points from delta encoding.
-			x := int(c.pts[0][0])
-			y := int(c.pts[0][1])
-			if len(c.pts) == 1 {
-				ctx.Set("fillStyle", hex)
-				ctx.Call("beginPath")
-				ctx.Call("arc", x, y, w/2, 0, 2*3.14159)
-				ctx.Call("fill")
-			} else {
-				ctx.Set("strokeStyle", hex)
-				ctx.Set("lineWidth", w)
-				ctx.Set("lineCap", "round")
-				ctx.Set("lineJoin", "round")
-				ctx.Call("beginPath")
-				ctx.Call("moveTo", x, y)
-				for _, p := range c.pts[1:] {
-					x += int(p[0])
-					y += int(p[1])
-					ctx.Call("lineTo", x, y)
-				}
-				ctx.Call("stroke")
-			}
-		case vecCmdClear:
-			ctx.Set("fillStyle", "white")
-			ctx.Call("fillRect", 0, 0, canvasWidth, canvasHeight)
-			for i := range imgData.Pix {
-				imgData.Pix[i] = 255
-			}
-		case vecCmdFill:
-			hex := colorToHex(color.RGBA{c.r, c.g, c.b, 255})
-			ctx.Set("fillStyle", hex)
-			ctx.Call("fillRect", 0, 0, canvasWidth, canvasHeight)
-			for i := 0; i < len(imgData.Pix); i += 4 {
-				imgData.Pix[i] = c.r
-				imgData.Pix[i+1] = c.g
-				imgData.Pix[i+2] = c.b
-				imgData.Pix[i+3] = 255
-			}
+	from := 0
+	var pix []byte
+	for _, c := range checkpoints {
+		if c.pos <= pos {
+			from, pix = c.pos, c.pix
 		}
 	}
-	// Sync imgData from canvas after replay.
-	jsID := ctx.Call("getImageData", 0, 0, canvasWidth, canvasHeight)
-	js.CopyBytesToGo(imgData.Pix, jsID.Get("data"))
+scan:
+	for i := pos - 1; i >= from; i-- {
+		switch vecCmds[i].(type) {
+		case codec.Clear, codec.Fill:
+			from, pix = i, nil
+			break scan
+		}
+	}
+	if pix != nil {
+		copy(imgData.Pix, pix)
+	} else {
+		render.Fill(imgData, canvasBg)
+	}
+	for i := from; i < pos; i++ {
+		saveCheckpoint(i)
+		render.Cmd(imgData, canvasBg, vecCmds[i])
+	}
+	flushImageData(imgData.Bounds())
 }
 
-// undoJS undoes the last committed command. Returns true if undo was possible.
+// undoJS undoes the last committed undo step. Returns true if undo was possible.
 func undoJS(this js.Value, args []js.Value) interface{} {
 	vecEndStroke() // commit any in-progress stroke first
+	endGroup()
 	if historyPos == 0 {
 		return false
 	}
-	historyPos--
+	historyPos = groupStart(vecCmds, historyPos-1)
 	applyHistoryAt(historyPos)
+	scheduleAutosave()
 	return true
 }
 
-// redoJS re-applies the next command after the current position.
+// redoJS re-applies the next undo step after the current position.
 // Returns true if redo was possible.
 func redoJS(this js.Value, args []js.Value) interface{} {
+	stopPlayback()
+	endGroup()
 	if historyPos >= len(vecCmds) {
 		return false
 	}
 	historyPos++
+	for historyPos < len(vecCmds) && isJoined(vecCmds[historyPos]) {
+		historyPos++
+	}
 	applyHistoryAt(historyPos)
+	scheduleAutosave()
 	return true
 }
 
@@ -1319,48 +2002,263 @@ func canUndoJS(this js.Value, args []js.Value) interface{} {
 	return historyPos > 0
 }
 
+// getBranchesJS lists the other lines of the undo tree, oldest first, as
+// [{fork, length}]: the commands each shares with the current line and its
+// length in commands.
+func getBranchesJS(this js.Value, args []js.Value) interface{} {
+	list := make([]interface{}, len(branches))
+	for i, line := range branches {
+		list[i] = map[string]interface{}{
+			"fork":   forkPoint(vecCmds, line),
+			"length": len(line),
+		}
+	}
+	return list
+}
+
+// switchBranchJS makes branch i of getBranches the current line, shown to its
+// end, and keeps the line it leaves as a branch in its place. Returns true if
+// the branch exists.
+func switchBranchJS(this js.Value, args []js.Value) interface{} {
+	vecEndStroke() // commit any in-progress stroke first
+	endGroup()
+	if len(args) == 0 || args[0].Type() != js.TypeNumber {
+		return false
+	}
+	i := args[0].Int()
+	if i < 0 || i >= len(branches) {
+		return false
+	}
+	dropCheckpoints(forkPoint(vecCmds, branches[i]))
+	vecCmds, branches[i] = branches[i], vecCmds
+	historyPos = len(vecCmds)
+	applyHistoryAt(historyPos)
+	scheduleAutosave()
+	return true
+}
+
 // canRedoJS returns true when there are commands ahead of the current position.
 func canRedoJS(this js.Value, args []js.Value) interface{} {
 	return historyPos < len(vecCmds)
 }
 
-func shiftVecCmds(dx, dy, limit int) {
-	for _, cmd := range vecCmds[:limit] {
-		s, ok := cmd.(*vecCmdStroke)
-		if !ok || len(s.pts) == 0 {
-			continue
-		}
-		// Rebuild absolute coordinates, shift each one, re-encode as deltas.
-		// pts[0] is stored as absolute (x, y); pts[1..] are int16 deltas.
-		abs := make([][2]int, len(s.pts))
-		abs[0][0] = int(s.pts[0][0]) + dx
-		abs[0][1] = int(s.pts[0][1]) + dy
-		for i := 1; i < len(s.pts); i++ {
-			abs[i][0] = abs[i-1][0] + int(s.pts[i][0])
-			abs[i][1] = abs[i-1][1] + int(s.pts[i][1])
+// player is the state of playHistory. While it runs, historyPos walks from 0
+// to end and imgData holds vecCmds[:historyPos] as usual; the stroke at
+// historyPos may be shown partly drawn on the canvas only.
+type player struct {
+	savedPos int     // historyPos to restore when playback stops
+	end      int     // playback finishes after this many commands
+	pts      int     // points shown of the stroke at historyPos
+	budget   float64 // drawing time earned since the last frame, not yet shown (ms)
+	speed    float64
+	paused   bool
+	last     float64 // requestAnimationFrame time of the previous frame; 0 to restart
+}
+
+var (
+	playback       *player // nil unless playing or paused
+	playbackTick   js.Func // requestAnimationFrame callback
+	playbackQueued bool    // a frame has been requested and not run yet
+)
+
+// playHistoryJS replays the board from the first command to the current undo
+// position, drawing strokes point by point at the pace render.Pace gives:
+// as recorded for timed strokes. speed is a multiplier (default 1). Called while paused it resumes, and
+// while playing it changes the speed. Returns false if there is nothing to
+// play.
+func playHistoryJS(this js.Value, args []js.Value) interface{} {
+	speed := 1.0
+	if len(args) > 0 && args[0].Type() == js.TypeNumber && args[0].Float() > 0 {
+		speed = args[0].Float()
+	}
+	if playback == nil {
+		vecEndStroke()
+		if historyPos == 0 {
+			return false
 		}
-		// Re-encode: first point absolute, rest as int16 deltas.
-		s.pts[0] = [2]int16{int16(abs[0][0]), int16(abs[0][1])}
-		for i := 1; i < len(abs); i++ {
-			ddx := abs[i][0] - abs[i-1][0]
-			ddy := abs[i][1] - abs[i-1][1]
-			if ddx > 32767 {
-				ddx = 32767
+		playback = &player{savedPos: historyPos, end: historyPos}
+		historyPos = 0
+		applyHistoryAt(0)
+	}
+	playback.speed = speed
+	playback.paused = false
+	playback.last = 0
+	schedulePlayback()
+	notifyPlayback()
+	return true
+}
+
+// pausePlaybackJS pauses playback on the current frame; playHistory resumes.
+func pausePlaybackJS(this js.Value, args []js.Value) interface{} {
+	if playback == nil {
+		return false
+	}
+	playback.paused = true
+	notifyPlayback()
+	return true
+}
+
+// seekPlaybackJS jumps playback to a command position between 0 and the end
+// (see getPlayback), keeping it playing or paused.
+func seekPlaybackJS(this js.Value, args []js.Value) interface{} {
+	if playback == nil || len(args) == 0 || args[0].Type() != js.TypeNumber {
+		return false
+	}
+	historyPos = max(0, min(args[0].Int(), playback.end))
+	playback.pts = 0
+	playback.budget = 0
+	applyHistoryAt(historyPos)
+	notifyPlayback()
+	return true
+}
+
+// stopPlaybackJS ends playback and restores the board as the user left it.
+func stopPlaybackJS(this js.Value, args []js.Value) interface{} {
+	stopPlayback()
+	return nil
+}
+
+// getHistoryJS returns {pos, length}: the undo position and the number of
+// commands in the current line, redo stack included.
+func getHistoryJS(this js.Value, args []js.Value) interface{} {
+	return map[string]interface{}{
+		"pos":    historyPos,
+		"length": len(vecCmds),
+	}
+}
+
+// getPlaybackJS returns null when no playback is active, otherwise
+// {pos, end, paused, speed}; pos counts the commands shown in full.
+func getPlaybackJS(this js.Value, args []js.Value) interface{} {
+	if playback == nil {
+		return nil
+	}
+	return map[string]interface{}{
+		"pos":    historyPos,
+		"end":    playback.end,
+		"paused": playback.paused,
+		"speed":  playback.speed,
+	}
+}
+
+// stopPlayback ends any playback and shows the history position it started
+// from again.
+func stopPlayback() {
+	if playback == nil {
+		return
+	}
+	historyPos = playback.savedPos
+	playback = nil
+	applyHistoryAt(historyPos)
+	notifyPlayback()
+}
+
+// dropPlayback ends playback without going back to where it started, for
+// callers about to replace the history it plays.
+func dropPlayback() {
+	if playback == nil {
+		return
+	}
+	playback = nil
+	notifyPlayback()
+}
+
+// notifyPlayback tells the page the playback state changed.
+func notifyPlayback() {
+	js.Global().Call("eval", "if(typeof onPlaybackChanged !== 'undefined') onPlaybackChanged();")
+}
+
+func schedulePlayback() {
+	if !playbackQueued {
+		playbackQueued = true
+		js.Global().Call("requestAnimationFrame", playbackTick)
+	}
+}
+
+// playbackFrame advances playback by the time passed since the previous
+// animation frame.
+func playbackFrame(this js.Value, args []js.Value) interface{} {
+	playbackQueued = false
+	p := playback
+	if p == nil || p.paused {
+		return nil
+	}
+	now := args[0].Float()
+	if p.last > 0 {
+		p.budget += (now - p.last) * p.speed
+	}
+	p.last = now
+
+	moved, grew := false, false
+	for historyPos < p.end {
+		s, isStroke := vecCmds[historyPos].(*codec.Stroke)
+		if !isStroke {
+			cost := render.Pace(vecCmds, historyPos, 0)
+			if p.budget < cost {
+				break
 			}
-			if ddx < -32768 {
-				ddx = -32768
+			p.budget -= cost
+		} else {
+			for p.pts < len(s.Pts) {
+				cost := render.Pace(vecCmds, historyPos, p.pts)
+				if p.budget < cost {
+					break
+				}
+				p.budget -= cost
+				p.pts++
+				grew = true
 			}
-			if ddy > 32767 {
-				ddy = 32767
+			if p.pts < len(s.Pts) {
+				break
+			}
+		}
+		flushImageData(render.Cmd(imgData, canvasBg, vecCmds[historyPos]))
+		historyPos++
+		p.pts = 0
+		moved = true
+	}
+	if grew && p.pts > 0 {
+		// Show the stroke so far over imgData without committing it there;
+		// each frame's part covers the part drawn the frame before.
+		s := *vecCmds[historyPos].(*codec.Stroke)
+		s.Pts = s.Pts[:p.pts]
+		r := render.Bounds(&s).Intersect(imgData.Bounds())
+		frame := image.NewRGBA(r)
+		draw.Draw(frame, r, imgData, r.Min, draw.Src)
+		render.Stroke(frame, &s)
+		putImage(frame, r)
+	}
+
+	if historyPos >= p.end {
+		stopPlayback()
+		return nil
+	}
+	if moved {
+		notifyPlayback()
+	}
+	schedulePlayback()
+	return nil
+}
+
+// shiftVecCmds translates all stroke coordinates in the vector history, redo
+// stack and branches included, by (dx, dy). Called after a resize that centers
+// the old content, so the vector record stays in sync with the visual pixel
+// positions on the new canvas.
+func shiftVecCmds(dx, dy int) {
+	// Lines share strokes; move each one once.
+	shifted := make(map[*codec.Stroke]bool)
+	for _, line := range append([][]codec.Cmd{vecCmds}, branches...) {
+		for _, cmd := range line {
+			s, ok := cmd.(*codec.Stroke)
+			if !ok || shifted[s] {
+				continue
 			}
-			if ddy < -32768 {
-				ddy = -32768
+			shifted[s] = true
+			for i := range s.Pts {
+				s.Pts[i][0] += dx
+				s.Pts[i][1] += dy
 			}
-			s.pts[i] = [2]int16{int16(ddx), int16(ddy)}
 		}
-		// Keep absX/absY in sync with the last point's new position.
-		s.absX += dx
-		s.absY += dy
 	}
 }
 
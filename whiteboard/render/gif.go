@@ -0,0 +1,272 @@
+package render
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/gif"
+	"io"
+	"math"
+
+	"github.com/raydac/bkbin2wav/codec"
+)
+
+// Replay pacing, in milliseconds of drawing time.
+const (
+	// UntimedPointDelay is the time a point of a stroke without capture
+	// times takes, about the rate mouse events arrive while drawing.
+	UntimedPointDelay = 1000.0 / 60
+	// CmdDelay is the time a clear or fill takes, so it stays visible for a
+	// moment.
+	CmdDelay = 250
+	// MaxPause is the longest recorded pause between two points replayed in
+	// full; longer ones are cut to it.
+	MaxPause = 1000
+
+	// MinSpeed and MaxSpeed bound TimelapseOptions.Speed.
+	MinSpeed = 1.0 / 64
+	MaxSpeed = 64.0
+	// minPacedFrame is the least drawing time a paced frame covers, whatever
+	// the speed.
+	minPacedFrame = 1.0
+)
+
+// Pace returns how long after the step before it a replay of cmds shows
+// point i of stroke cmds[c], or the whole of cmds[c] for a clear or fill.
+// Timed strokes follow their capture times, and the first point of one that
+// directly follows another timed stroke waits for the recorded gap.
+func Pace(cmds []codec.Cmd, c, i int) float64 {
+	s, ok := cmds[c].(*codec.Stroke)
+	if !ok {
+		return CmdDelay
+	}
+	if !s.Timed() {
+		return UntimedPointDelay
+	}
+	var gap int64
+	if i > 0 {
+		gap = s.Times[i] - s.Times[i-1]
+	} else if c > 0 {
+		if prev, ok := cmds[c-1].(*codec.Stroke); ok && prev.Timed() {
+			gap = s.Times[0] - prev.Times[len(prev.Times)-1]
+		}
+	}
+	return float64(max(0, min(gap, MaxPause)))
+}
+
+// TimelapseOptions controls Timelapse. Zero fields take the defaults noted.
+type TimelapseOptions struct {
+	// PointsPerFrame draws strokes progressively, this many points per frame.
+	// Zero shows every command complete in a single frame.
+	PointsPerFrame int
+	// Speed > 0 follows the drawing pace instead (see Pace): each frame
+	// covers Delay times Speed of drawing time, and PointsPerFrame is
+	// ignored. It must lie between MinSpeed and MaxSpeed.
+	Speed     float64
+	Delay     int // frame delay in 100ths of a second; default 10
+	MaxFrames int // frame budget; default 300
+}
+
+// Timelapse writes an animated GIF that replays cmds the way they were drawn:
+// one frame per command, or per PointsPerFrame points of a stroke. When that
+// takes more than MaxFrames frames, frames are dropped evenly so the whole
+// history still fits. With Speed set, frames follow the drawing pace instead
+// and the pace is sped up as far as the frame budget requires. The last frame
+// always shows the finished board and is held for at least a second before
+// the animation loops.
+func Timelapse(w io.Writer, width, height int, bg color.RGBA, cmds []codec.Cmd, opt TimelapseOptions) error {
+	if opt.Delay <= 0 {
+		opt.Delay = 10
+	}
+	if opt.MaxFrames <= 0 {
+		opt.MaxFrames = 300
+	}
+	if opt.Speed != 0 && !(opt.Speed >= MinSpeed && opt.Speed <= MaxSpeed) {
+		return fmt.Errorf("render: time-lapse speed %g is outside %g to %g", opt.Speed, MinSpeed, MaxSpeed)
+	}
+
+	var steps []timelapseStep
+	if opt.Speed > 0 {
+		// Slow the frame clock down until the history fits the budget.
+		frame := float64(opt.Delay) * 10 * opt.Speed
+		steps = pacedSteps(cmds, frame, opt.Delay)
+		for len(steps) > opt.MaxFrames {
+			frame *= 2
+			steps = pacedSteps(cmds, frame, opt.Delay)
+		}
+	} else {
+		steps = fixedSteps(cmds, opt.PointsPerFrame, opt.Delay)
+		if len(steps) > opt.MaxFrames {
+			picked := make([]timelapseStep, opt.MaxFrames)
+			for j := range picked {
+				picked[j] = steps[(j+1)*len(steps)/opt.MaxFrames-1]
+			}
+			steps = picked
+		}
+	}
+
+	pal := timelapsePalette(bg, cmds)
+	base := image.NewRGBA(image.Rect(0, 0, width, height))
+	Fill(base, bg)
+	frame := image.NewRGBA(base.Rect)
+	prev := image.NewRGBA(base.Rect)
+	done := 0
+	anim := gif.GIF{Config: image.Config{ColorModel: pal, Width: width, Height: height}}
+	for j, st := range steps {
+		for ; done < st.cmd; done++ {
+			Cmd(base, bg, cmds[done])
+		}
+		copy(frame.Pix, base.Pix)
+		if st.pts > 0 {
+			s := *cmds[st.cmd].(*codec.Stroke)
+			s.Pts = s.Pts[:st.pts]
+			Stroke(frame, &s)
+		}
+
+		r := frame.Rect
+		if j > 0 {
+			// Later frames only carry the rectangle that changed and leave
+			// the rest of the previous frame in place.
+			r = changedRect(prev, frame)
+			if r.Empty() {
+				r = image.Rect(0, 0, 1, 1)
+			}
+		}
+		anim.Image = append(anim.Image, paletted(frame, r, pal))
+		anim.Delay = append(anim.Delay, st.delay)
+		anim.Disposal = append(anim.Disposal, gif.DisposalNone)
+		frame, prev = prev, frame
+	}
+	if last := len(anim.Delay) - 1; anim.Delay[last] < 100 {
+		anim.Delay[last] = 100
+	}
+
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, &anim); err != nil {
+		return err
+	}
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+// timelapseStep is a frame showing the board with cmds[:cmd] done and the
+// first pts points of cmds[cmd] drawn, for delay 100ths of a second.
+type timelapseStep struct{ cmd, pts, delay int }
+
+// fixedSteps returns a step per command, or per perFrame points of a stroke.
+func fixedSteps(cmds []codec.Cmd, perFrame, delay int) []timelapseStep {
+	var steps []timelapseStep
+	for i, cmd := range cmds {
+		if s, ok := cmd.(*codec.Stroke); ok && perFrame > 0 {
+			for n := perFrame; n < len(s.Pts); n += perFrame {
+				steps = append(steps, timelapseStep{i, n, delay})
+			}
+		}
+		steps = append(steps, timelapseStep{i + 1, 0, delay})
+	}
+	if len(steps) == 0 {
+		steps = append(steps, timelapseStep{delay: delay})
+	}
+	return steps
+}
+
+// pacedSteps samples the replay of cmds every frame milliseconds of drawing
+// time, at least minPacedFrame. Frames that would show nothing new lengthen
+// the one before instead.
+func pacedSteps(cmds []codec.Cmd, frame float64, delay int) []timelapseStep {
+	frame = max(frame, minPacedFrame)
+	var steps []timelapseStep
+	show := func(cmd, pts int) {
+		if n := len(steps); n > 0 && steps[n-1].cmd == cmd && steps[n-1].pts == pts {
+			steps[n-1].delay += delay
+			return
+		}
+		steps = append(steps, timelapseStep{cmd, pts, delay})
+	}
+	clock, next := 0.0, frame
+	for c, cmd := range cmds {
+		n := 1
+		if s, ok := cmd.(*codec.Stroke); ok {
+			n = len(s.Pts)
+		}
+		for i := 0; i < n; i++ {
+			clock += Pace(cmds, c, i)
+			if next < clock {
+				// Every frame due by now shows this point.
+				due := math.Ceil((clock - next) / frame)
+				show(c, i)
+				steps[len(steps)-1].delay += (int(due) - 1) * delay
+				next += due * frame
+			}
+		}
+	}
+	show(len(cmds), 0)
+	return steps
+}
+
+// timelapsePalette holds the exact colours of the board (background, fills
+// and pens, as many as fit) followed by the web-safe palette for the
+// anti-aliased edges in between.
+func timelapsePalette(bg color.RGBA, cmds []codec.Cmd) color.Palette {
+	const maxExact = 256 - 216
+	pal := color.Palette{bg}
+	seen := map[color.RGBA]bool{bg: true}
+	add := func(c color.RGBA) {
+		if !seen[c] && len(pal) < maxExact {
+			seen[c] = true
+			pal = append(pal, c)
+		}
+	}
+	for _, cmd := range cmds {
+		switch c := cmd.(type) {
+		case *codec.Stroke:
+			add(color.RGBA{c.R, c.G, c.B, 255})
+		case codec.Fill:
+			add(color.RGBA{c.R, c.G, c.B, 255})
+		}
+	}
+	return append(pal, palette.WebSafe...)
+}
+
+// changedRect returns the smallest rectangle outside which a and b are equal.
+func changedRect(a, b *image.RGBA) image.Rectangle {
+	var r image.Rectangle
+	for y := a.Rect.Min.Y; y < a.Rect.Max.Y; y++ {
+		ra := a.Pix[a.PixOffset(a.Rect.Min.X, y):a.PixOffset(a.Rect.Max.X, y)]
+		rb := b.Pix[b.PixOffset(b.Rect.Min.X, y):b.PixOffset(b.Rect.Max.X, y)]
+		if bytes.Equal(ra, rb) {
+			continue
+		}
+		x0, x1 := 0, len(ra)
+		for ra[x0] == rb[x0] {
+			x0++
+		}
+		for ra[x1-1] == rb[x1-1] {
+			x1--
+		}
+		r = r.Union(image.Rect(a.Rect.Min.X+x0/4, y, a.Rect.Min.X+(x1+3)/4, y+1))
+	}
+	return r
+}
+
+// paletted converts rectangle r of img to pal, mapping every colour to the
+// nearest palette entry without dithering so unchanged areas stay stable
+// from frame to frame.
+func paletted(img *image.RGBA, r image.Rectangle, pal color.Palette) *image.Paletted {
+	dst := image.NewPaletted(r, pal)
+	cache := make(map[color.RGBA]uint8)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			c := img.RGBAAt(x, y)
+			i, ok := cache[c]
+			if !ok {
+				i = uint8(pal.Index(c))
+				cache[c] = i
+			}
+			dst.SetColorIndex(x, y, i)
+		}
+	}
+	return dst
+}
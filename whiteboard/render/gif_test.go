@@ -0,0 +1,130 @@
+package render
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"io"
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/raydac/bkbin2wav/codec"
+)
+
+func decodeTimelapse(t *testing.T, width, height int, bg color.RGBA, cmds []codec.Cmd, opt TimelapseOptions) *gif.GIF {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := Timelapse(&buf, width, height, bg, cmds, opt); err != nil {
+		t.Fatalf("Timelapse: %v", err)
+	}
+	anim, err := gif.DecodeAll(&buf)
+	if err != nil {
+		t.Fatalf("gif.DecodeAll: %v", err)
+	}
+	return anim
+}
+
+func TestTimelapseFrames(t *testing.T) {
+	long := &codec.Stroke{Width: 2, Pts: [][2]int{{0, 0}, {5, 9}, {10, 0}, {15, 9}, {20, 0}, {25, 9}, {30, 0}, {35, 9}, {40, 0}, {45, 9}}}
+	// The pause before the last point is cut to MaxPause.
+	timed := &codec.Stroke{Width: 2, Pts: [][2]int{{0, 0}, {20, 5}, {40, 0}, {60, 5}}, Times: []int64{0, 100, 200, 1700}}
+	tests := []struct {
+		name   string
+		cmds   []codec.Cmd
+		opt    TimelapseOptions
+		delays []int
+	}{
+		{"empty", nil, TimelapseOptions{}, []int{100}},
+		{"one frame per command", []codec.Cmd{codec.Fill{R: 9}, long, codec.Clear{}},
+			TimelapseOptions{}, []int{10, 10, 100}},
+		{"points per frame", []codec.Cmd{long},
+			TimelapseOptions{PointsPerFrame: 4, Delay: 5}, []int{5, 5, 100}},
+		{"frame budget", []codec.Cmd{codec.Fill{R: 1}, codec.Fill{R: 2}, codec.Fill{R: 3}, codec.Fill{R: 4}, codec.Fill{R: 5}},
+			TimelapseOptions{MaxFrames: 2}, []int{10, 100}},
+		{"long last frame kept", []codec.Cmd{long},
+			TimelapseOptions{Delay: 150}, []int{150}},
+		{"paced", []codec.Cmd{timed},
+			TimelapseOptions{Speed: 1}, []int{10, 100, 100}},
+		{"paced faster", []codec.Cmd{timed},
+			TimelapseOptions{Speed: 2}, []int{50, 100}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			anim := decodeTimelapse(t, 64, 32, white, tt.cmds, tt.opt)
+			if len(anim.Image) != len(tt.delays) {
+				t.Fatalf("%d frames, want %d", len(anim.Image), len(tt.delays))
+			}
+			if !reflect.DeepEqual(anim.Delay, tt.delays) {
+				t.Errorf("delays = %v, want %v", anim.Delay, tt.delays)
+			}
+			if anim.Config.Width != 64 || anim.Config.Height != 32 {
+				t.Errorf("size = %dx%d, want 64x32", anim.Config.Width, anim.Config.Height)
+			}
+		})
+	}
+}
+
+// The background and pen colours are in the palette exactly, so the last
+// frame shows them unchanged.
+func TestTimelapsePalette(t *testing.T) {
+	bg := color.RGBA{250, 240, 230, 255}
+	pen := color.RGBA{12, 34, 56, 255}
+	cmds := []codec.Cmd{&codec.Stroke{R: pen.R, G: pen.G, B: pen.B, Width: 6, Pts: [][2]int{{10, 10}, {50, 20}}}}
+	anim := decodeTimelapse(t, 64, 32, bg, cmds, TimelapseOptions{})
+
+	pal := anim.Image[0].Palette
+	if len(pal) > 256 {
+		t.Fatalf("palette has %d colours", len(pal))
+	}
+	if pal[0] != bg || pal[1] != pen {
+		t.Errorf("palette starts %v %v, want %v %v", pal[0], pal[1], bg, pen)
+	}
+
+	// Frames after the first only carry what changed; compose them.
+	board := image.NewRGBA(image.Rect(0, 0, 64, 32))
+	for _, frame := range anim.Image {
+		draw.Draw(board, frame.Bounds(), frame, frame.Bounds().Min, draw.Src)
+	}
+	if got := board.RGBAAt(1, 1); got != bg {
+		t.Errorf("background pixel = %v, want %v", got, bg)
+	}
+	if got := board.RGBAAt(30, 15); got != pen {
+		t.Errorf("stroke pixel = %v, want %v", got, pen)
+	}
+}
+
+func TestTimelapseEmpty(t *testing.T) {
+	anim := decodeTimelapse(t, 16, 16, white, nil, TimelapseOptions{})
+	frame := anim.Image[0]
+	if frame.Bounds() != image.Rect(0, 0, 16, 16) {
+		t.Fatalf("frame bounds = %v, want the whole board", frame.Bounds())
+	}
+	for y := range 16 {
+		for x := range 16 {
+			if got := frame.At(x, y); got != white {
+				t.Fatalf("pixel (%d, %d) = %v, want %v", x, y, got, white)
+			}
+		}
+	}
+}
+
+func TestTimelapseSpeed(t *testing.T) {
+	cmds := []codec.Cmd{&codec.Stroke{Width: 2, Pts: [][2]int{{0, 0}, {20, 5}, {40, 0}, {60, 5}}, Times: []int64{0, 100, 200, 1700}}}
+	for _, speed := range []float64{-1, 1e-300, MinSpeed / 2, MaxSpeed * 2, math.Inf(1), math.NaN()} {
+		if err := Timelapse(io.Discard, 64, 32, white, cmds, TimelapseOptions{Speed: speed}); err == nil {
+			t.Errorf("Timelapse accepted speed %g", speed)
+		}
+	}
+	for _, speed := range []float64{MinSpeed, MaxSpeed} {
+		decodeTimelapse(t, 64, 32, white, cmds, TimelapseOptions{Speed: speed})
+	}
+
+	// However small the frame, pacing returns a step per point shown.
+	want := []timelapseStep{{0, 1, 990}, {0, 2, 1000}, {0, 3, 10000}, {1, 0, 10}}
+	if got := pacedSteps(cmds, 1e-300, 10); !reflect.DeepEqual(got, want) {
+		t.Errorf("pacedSteps = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,109 @@
+package render
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"image/color"
+	"io"
+	"math"
+	"strconv"
+
+	"github.com/raydac/bkbin2wav/codec"
+)
+
+// pdfScale maps canvas pixels to PDF points at the CSS 96 dpi, so a board
+// prints at the size it has on screen.
+const pdfScale = 72.0 / 96
+
+// PDF writes cmds as a one-page PDF document whose page is the canvas, drawn
+// with vector path operators like SVG draws it: the page is filled with bg,
+// every stroke is a round-capped path and every clear or fill paints the
+// whole page. Like SVG it writes hidden commands too.
+func PDF(w io.Writer, width, height int, bg color.RGBA, cmds []codec.Cmd) error {
+	var content bytes.Buffer
+	fmt.Fprintf(&content, "%s 0 0 %s 0 %s cm\n1 J 1 j\n",
+		pdfNum(pdfScale), pdfNum(-pdfScale), pdfNum(float64(height)*pdfScale))
+	pdfRect(&content, width, height, bg)
+	for _, cmd := range cmds {
+		switch c := cmd.(type) {
+		case *codec.Stroke:
+			pdfStroke(&content, c)
+		case codec.Clear:
+			pdfRect(&content, width, height, bg)
+		case codec.Fill:
+			pdfRect(&content, width, height, color.RGBA{c.R, c.G, c.B, 255})
+		}
+	}
+	var stream bytes.Buffer
+	zw := zlib.NewWriter(&stream)
+	zw.Write(content.Bytes())
+	zw.Close()
+
+	// Objects are numbered from 1 in the order they are written; xref
+	// records where each one starts.
+	var doc bytes.Buffer
+	var xref []int
+	object := func(body string) {
+		xref = append(xref, doc.Len())
+		fmt.Fprintf(&doc, "%d 0 obj\n%s\nendobj\n", len(xref), body)
+	}
+	doc.WriteString("%PDF-1.4\n%\xE2\xE3\xCF\xD3\n")
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	object("<< /Type /Pages /Kids [3 0 R] /Count 1 >>")
+	object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %s %s] /Resources << >> /Contents 4 0 R >>",
+		pdfNum(float64(width)*pdfScale), pdfNum(float64(height)*pdfScale)))
+	object(fmt.Sprintf("<< /Length %d /Filter /FlateDecode >>\nstream\n%s\nendstream", stream.Len(), stream.Bytes()))
+	start := doc.Len()
+	fmt.Fprintf(&doc, "xref\n0 %d\n0000000000 65535 f \n", len(xref)+1)
+	for _, off := range xref {
+		fmt.Fprintf(&doc, "%010d 00000 n \n", off)
+	}
+	fmt.Fprintf(&doc, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(xref)+1, start)
+	_, err := w.Write(doc.Bytes())
+	return err
+}
+
+func pdfRect(w *bytes.Buffer, width, height int, c color.RGBA) {
+	fmt.Fprintf(w, "%s rg 0 0 %d %d re f\n", pdfColor(c), width, height)
+}
+
+func pdfStroke(w *bytes.Buffer, s *codec.Stroke) {
+	if len(s.Pts) == 0 {
+		return
+	}
+	width := int(s.Width)
+	if len(s.Pts) == 1 {
+		// Same dot as Stroke draws: radius Width/2 rounded down. A round cap
+		// on a zero-length line is a dot of the line width.
+		if width = int(s.Width/2) * 2; width == 0 {
+			return
+		}
+	}
+	fmt.Fprintf(w, "%s RG %d w %d %d m", pdfColor(color.RGBA{s.R, s.G, s.B, 255}), width, s.Pts[0][0], s.Pts[0][1])
+	pts := s.Pts[1:]
+	if len(pts) == 0 {
+		pts = s.Pts
+	}
+	var buf []byte
+	for _, p := range pts {
+		buf = append(buf[:0], ' ')
+		buf = strconv.AppendInt(buf, int64(p[0]), 10)
+		buf = append(buf, ' ')
+		buf = strconv.AppendInt(buf, int64(p[1]), 10)
+		buf = append(buf, " l"...)
+		w.Write(buf)
+	}
+	w.WriteString(" S\n")
+}
+
+// pdfColor formats c as the three operands of rg or RG.
+func pdfColor(c color.RGBA) string {
+	return pdfNum(float64(c.R)/255) + " " + pdfNum(float64(c.G)/255) + " " + pdfNum(float64(c.B)/255)
+}
+
+// pdfNum formats v with at most four decimals, as PDF wants reals: no
+// exponent.
+func pdfNum(v float64) string {
+	return strconv.FormatFloat(math.Round(v*10000)/10000, 'f', -1, 64)
+}
@@ -0,0 +1,155 @@
+package render
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"image/color"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/raydac/bkbin2wav/codec"
+)
+
+// pdfObjects checks the cross-reference table of a PDF written by PDF and
+// returns the bodies of its objects, indexed from 1 as they are numbered.
+func pdfObjects(t *testing.T, doc []byte) []string {
+	t.Helper()
+	if !bytes.HasPrefix(doc, []byte("%PDF-1.4\n")) || !bytes.HasSuffix(doc, []byte("%%EOF\n")) {
+		t.Fatalf("not a PDF document:\n%q", doc)
+	}
+	m := regexp.MustCompile(`startxref\n(\d+)\n%%EOF\n$`).FindSubmatch(doc)
+	if m == nil {
+		t.Fatal("no startxref")
+	}
+	start, _ := strconv.Atoi(string(m[1]))
+	if start >= len(doc) || !bytes.HasPrefix(doc[start:], []byte("xref\n")) {
+		t.Fatalf("startxref %d does not point at the xref table", start)
+	}
+	lines := strings.Split(string(doc[start:]), "\n")
+	var first, count int
+	if _, err := fmt.Sscanf(lines[1], "%d %d", &first, &count); err != nil || first != 0 {
+		t.Fatalf("xref subsection %q", lines[1])
+	}
+	if lines[2] != "0000000000 65535 f " {
+		t.Errorf("xref entry 0 = %q", lines[2])
+	}
+	if want := fmt.Sprintf("<< /Size %d /Root 1 0 R >>", count); lines[count+3] != want {
+		t.Errorf("trailer = %q, want %q", lines[count+3], want)
+	}
+	objs := make([]string, count)
+	for i := 1; i < count; i++ {
+		entry := lines[i+2]
+		if len(entry) != 19 || !strings.HasSuffix(entry, " 00000 n ") {
+			t.Fatalf("xref entry %d = %q", i, entry)
+		}
+		off, _ := strconv.Atoi(entry[:10])
+		head := fmt.Sprintf("%d 0 obj\n", i)
+		if off >= start || !bytes.HasPrefix(doc[off:], []byte(head)) {
+			t.Fatalf("xref entry %d points at %q, want %q", i, doc[off:min(off+12, len(doc))], head)
+		}
+		body := string(doc[off+len(head):])
+		end := strings.Index(body, "\nendobj\n")
+		if end < 0 {
+			t.Fatalf("object %d has no endobj", i)
+		}
+		objs[i] = body[:end]
+	}
+	return objs
+}
+
+// pdfContent inflates the content stream of the page, object 4.
+func pdfContent(t *testing.T, obj string) string {
+	t.Helper()
+	m := regexp.MustCompile(`(?s)^<< /Length (\d+) /Filter /FlateDecode >>\nstream\n(.*)\nendstream$`).FindStringSubmatch(obj)
+	if m == nil {
+		t.Fatalf("content object = %q", obj)
+	}
+	if n, _ := strconv.Atoi(m[1]); n != len(m[2]) {
+		t.Errorf("/Length %d, stream is %d bytes", n, len(m[2]))
+	}
+	zr, err := zlib.NewReader(strings.NewReader(m[2]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(content)
+}
+
+func TestPDF(t *testing.T) {
+	tests := []struct {
+		name          string
+		width, height int
+		mediaBox      string
+		cmds          []codec.Cmd
+		content       string
+	}{
+		{"empty", 640, 480, "0 0 480 360", nil,
+			"0.75 0 0 -0.75 0 360 cm\n1 J 1 j\n" +
+				"1 1 1 rg 0 0 640 480 re f\n"},
+		{"strokes", 100, 75, "0 0 75 56.25", []codec.Cmd{
+			&codec.Stroke{R: 255, G: 51, Width: 4, Pts: [][2]int{{-5, 7}, {90, 2}, {3, 70}}},
+			&codec.Stroke{B: 255, Width: 5, Pts: [][2]int{{10, 20}}},
+			&codec.Stroke{Width: 1, Pts: [][2]int{{10, 20}}},
+		}, "0.75 0 0 -0.75 0 56.25 cm\n1 J 1 j\n" +
+			"1 1 1 rg 0 0 100 75 re f\n" +
+			"1 0.2 0 RG 4 w -5 7 m 90 2 l 3 70 l S\n" +
+			"0 0 1 RG 4 w 10 20 m 10 20 l S\n"},
+		{"clear and fill", 64, 64, "0 0 48 48", []codec.Cmd{codec.Fill{R: 128}, codec.Clear{}},
+			"0.75 0 0 -0.75 0 48 cm\n1 J 1 j\n" +
+				"1 1 1 rg 0 0 64 64 re f\n" +
+				"0.502 0 0 rg 0 0 64 64 re f\n" +
+				"1 1 1 rg 0 0 64 64 re f\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := PDF(&buf, tt.width, tt.height, white, tt.cmds); err != nil {
+				t.Fatal(err)
+			}
+			objs := pdfObjects(t, buf.Bytes())
+			if len(objs) != 5 {
+				t.Fatalf("%d objects, want 4", len(objs)-1)
+			}
+			if want := "<< /Type /Catalog /Pages 2 0 R >>"; objs[1] != want {
+				t.Errorf("catalog = %q, want %q", objs[1], want)
+			}
+			page := "<< /Type /Page /Parent 2 0 R /MediaBox [" + tt.mediaBox + "] /Resources << >> /Contents 4 0 R >>"
+			if objs[3] != page {
+				t.Errorf("page = %q, want %q", objs[3], page)
+			}
+			if got := pdfContent(t, objs[4]); got != tt.content {
+				t.Errorf("content =\n%s\nwant\n%s", got, tt.content)
+			}
+		})
+	}
+}
+
+func TestPDFNum(t *testing.T) {
+	tests := []struct {
+		v    float64
+		want string
+	}{
+		{0, "0"},
+		{360, "360"},
+		{56.25, "56.25"},
+		{1.0 / 3, "0.3333"},
+		{-0.75, "-0.75"},
+		{1e-7, "0"},
+		{2048 * 0.75, "1536"},
+	}
+	for _, tt := range tests {
+		if got := pdfNum(tt.v); got != tt.want {
+			t.Errorf("pdfNum(%v) = %q, want %q", tt.v, got, tt.want)
+		}
+	}
+	if got := pdfColor(color.RGBA{255, 128, 0, 255}); got != "1 0.502 0" {
+		t.Errorf("pdfColor = %q, want %q", got, "1 0.502 0")
+	}
+}
@@ -0,0 +1,173 @@
+// Package render rasterizes the whiteboard command log in pure Go the way the
+// Canvas2D front end draws it: anti-aliased strokes with round caps and joins,
+// and full-canvas clears and fills. It has no browser dependencies, so the
+// wasm front end can keep its pixel buffer authoritative and native tools can
+// render share links without a canvas. SVG and PDF write the same log as
+// vector documents.
+package render
+
+import (
+	"image"
+	"image/color"
+	"math"
+
+	"github.com/raydac/bkbin2wav/codec"
+)
+
+// Replay paints dst with bg and draws cmds over it in order.
+func Replay(dst *image.RGBA, bg color.RGBA, cmds []codec.Cmd) {
+	Fill(dst, bg)
+	for _, cmd := range cmds {
+		Cmd(dst, bg, cmd)
+	}
+}
+
+// Cmd draws one command onto dst; Clear restores bg. It returns the rectangle
+// of dst that changed.
+func Cmd(dst *image.RGBA, bg color.RGBA, cmd codec.Cmd) image.Rectangle {
+	switch c := cmd.(type) {
+	case *codec.Stroke:
+		return Stroke(dst, c)
+	case codec.Clear:
+		Fill(dst, bg)
+		return dst.Bounds()
+	case codec.Fill:
+		Fill(dst, color.RGBA{c.R, c.G, c.B, 255})
+		return dst.Bounds()
+	}
+	return image.Rectangle{}
+}
+
+// Fill paints the whole of dst with c.
+func Fill(dst *image.RGBA, c color.RGBA) {
+	r := dst.Bounds()
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		row := dst.Pix[dst.PixOffset(r.Min.X, y):dst.PixOffset(r.Max.X, y)]
+		for i := 0; i < len(row); i += 4 {
+			row[i], row[i+1], row[i+2], row[i+3] = c.R, c.G, c.B, c.A
+		}
+	}
+}
+
+// Stroke draws s onto dst and returns the rectangle it may have changed.
+//
+// Points are pixel-corner coordinates, as on a canvas, so a pixel is covered by
+// how far its centre lies inside the pen: coverage = radius + 0.5 - distance,
+// clamped to [0, 1]. Coverage is collected for the whole stroke first and
+// composited once, so joints where segments overlap are not painted twice and
+// every join and cap comes out round. A one-point stroke is a dot of radius
+// Width/2 rounded down, like the canvas arc the front end draws for it.
+func Stroke(dst *image.RGBA, s *codec.Stroke) image.Rectangle {
+	radius := strokeRadius(s)
+	box := Bounds(s).Intersect(dst.Bounds())
+	if box.Empty() {
+		return image.Rectangle{}
+	}
+
+	cov := make([]uint8, box.Dx()*box.Dy())
+	if len(s.Pts) == 1 {
+		coverSegment(cov, box, s.Pts[0], s.Pts[0], radius)
+	}
+	for i := 1; i < len(s.Pts); i++ {
+		coverSegment(cov, box, s.Pts[i-1], s.Pts[i], radius)
+	}
+
+	for y := box.Min.Y; y < box.Max.Y; y++ {
+		row := cov[(y-box.Min.Y)*box.Dx():]
+		for x := box.Min.X; x < box.Max.X; x++ {
+			a := uint32(row[x-box.Min.X])
+			if a == 0 {
+				continue
+			}
+			i := dst.PixOffset(x, y)
+			px := dst.Pix[i : i+4 : i+4]
+			px[0] = blend(px[0], s.R, a)
+			px[1] = blend(px[1], s.G, a)
+			px[2] = blend(px[2], s.B, a)
+			px[3] = blend(px[3], 255, a)
+		}
+	}
+	return box
+}
+
+// Bounds returns the rectangle Stroke may change when drawing s on an
+// unbounded image; it is empty when s draws nothing.
+func Bounds(s *codec.Stroke) image.Rectangle {
+	radius := strokeRadius(s)
+	if len(s.Pts) == 0 || radius <= 0 {
+		return image.Rectangle{}
+	}
+	minX, minY := s.Pts[0][0], s.Pts[0][1]
+	maxX, maxY := minX, minY
+	for _, p := range s.Pts[1:] {
+		minX, maxX = min(minX, p[0]), max(maxX, p[0])
+		minY, maxY = min(minY, p[1]), max(maxY, p[1])
+	}
+	pad := int(math.Ceil(radius)) + 1
+	return image.Rect(minX-pad, minY-pad, maxX+pad, maxY+pad)
+}
+
+// strokeRadius is the pen radius of s: half its width, rounded down for the
+// dot of a one-point stroke.
+func strokeRadius(s *codec.Stroke) float64 {
+	if len(s.Pts) == 1 {
+		return float64(s.Width / 2)
+	}
+	return float64(s.Width) / 2
+}
+
+// coverSegment raises the coverage in cov (laid out over box) of every pixel
+// within radius of the segment p0-p1.
+func coverSegment(cov []uint8, box image.Rectangle, p0, p1 [2]int, radius float64) {
+	ax, ay := float64(p0[0]), float64(p0[1])
+	bx, by := float64(p1[0]), float64(p1[1])
+	dx, dy := bx-ax, by-ay
+	lenSq := dx*dx + dy*dy
+	slab := (radius + 1) * math.Sqrt(lenSq)
+
+	pad := int(math.Ceil(radius)) + 1
+	r := image.Rect(min(p0[0], p1[0])-pad, min(p0[1], p1[1])-pad,
+		max(p0[0], p1[0])+pad, max(p0[1], p1[1])+pad).Intersect(box)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		cy := float64(y) + 0.5
+		row := cov[(y-box.Min.Y)*box.Dx():]
+		x0, x1 := r.Min.X, r.Max.X
+		if dy != 0 {
+			// Only pixels near the line through the segment can be covered,
+			// which keeps long diagonal segments from scanning their whole box.
+			xa := ax + ((cy-ay)*dx-slab)/dy
+			xb := ax + ((cy-ay)*dx+slab)/dy
+			if xa > xb {
+				xa, xb = xb, xa
+			}
+			x0 = max(x0, int(math.Floor(xa)))
+			x1 = min(x1, int(math.Ceil(xb))+1)
+		}
+		for x := x0; x < x1; x++ {
+			cx := float64(x) + 0.5
+			// Distance from the pixel centre to the closest point of the segment.
+			t := 0.0
+			if lenSq > 0 {
+				t = ((cx-ax)*dx + (cy-ay)*dy) / lenSq
+				t = math.Max(0, math.Min(1, t))
+			}
+			ex, ey := cx-(ax+t*dx), cy-(ay+t*dy)
+			c := radius + 0.5 - math.Sqrt(ex*ex+ey*ey)
+			if c <= 0 {
+				continue
+			}
+			v := uint8(255)
+			if c < 1 {
+				v = uint8(c*255 + 0.5)
+			}
+			if v > row[x-box.Min.X] {
+				row[x-box.Min.X] = v
+			}
+		}
+	}
+}
+
+// blend mixes src over dst with alpha a in [0, 255].
+func blend(dst, src uint8, a uint32) uint8 {
+	return uint8((uint32(dst)*(255-a) + uint32(src)*a + 127) / 255)
+}
@@ -0,0 +1,143 @@
+package render
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/raydac/bkbin2wav/codec"
+)
+
+var (
+	white = color.RGBA{255, 255, 255, 255}
+	black = color.RGBA{0, 0, 0, 255}
+)
+
+func newCanvas(cmds ...codec.Cmd) *image.RGBA {
+	dst := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	Replay(dst, white, cmds)
+	return dst
+}
+
+func TestReplayBackground(t *testing.T) {
+	bg := color.RGBA{10, 20, 30, 255}
+	dst := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	Replay(dst, bg, nil)
+	for y := range 8 {
+		for x := range 8 {
+			if got := dst.RGBAAt(x, y); got != bg {
+				t.Fatalf("pixel (%d, %d) = %v, want %v", x, y, got, bg)
+			}
+		}
+	}
+}
+
+func TestStrokePixels(t *testing.T) {
+	tests := []struct {
+		name   string
+		stroke *codec.Stroke
+		pixels map[image.Point]color.RGBA
+	}{
+		// Centres a pixel or more inside the pen are covered fully, those
+		// on the edge partly, and those outside not at all.
+		{"anti-aliased edge", &codec.Stroke{Width: 3, Pts: [][2]int{{20, 20}, {40, 20}}},
+			map[image.Point]color.RGBA{
+				{30, 19}: black, {30, 20}: black,
+				{30, 18}: {127, 127, 127, 255}, {30, 21}: {127, 127, 127, 255},
+				{30, 17}: white, {30, 22}: white,
+			}},
+		// A square cap would cover the corners of the pen around an end.
+		{"round cap", &codec.Stroke{Width: 10, Pts: [][2]int{{20, 20}, {40, 20}}},
+			map[image.Point]color.RGBA{
+				{15, 20}: {7, 7, 7, 255}, {16, 20}: black,
+				{15, 15}: white, {44, 24}: white,
+			}},
+		// A miter join would fill the outer corner of the bend.
+		{"round join", &codec.Stroke{Width: 6, Pts: [][2]int{{20, 20}, {40, 20}, {40, 40}}},
+			map[image.Point]color.RGBA{
+				{40, 20}: black, {42, 19}: {13, 13, 13, 255},
+				{42, 18}: {106, 106, 106, 255}, {42, 17}: white,
+			}},
+		// A dot has a radius of half the width, rounded down.
+		{"dot", &codec.Stroke{R: 255, Width: 5, Pts: [][2]int{{30, 30}}},
+			map[image.Point]color.RGBA{
+				{29, 29}: {255, 0, 0, 255}, {30, 30}: {255, 0, 0, 255},
+				{31, 29}: {255, 21, 21, 255}, {32, 29}: white,
+			}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dst := newCanvas(tt.stroke)
+			for p, want := range tt.pixels {
+				if got := dst.RGBAAt(p.X, p.Y); got != want {
+					t.Errorf("pixel %v = %v, want %v", p, got, want)
+				}
+			}
+		})
+	}
+}
+
+// Where segments of one stroke overlap, edge pixels are blended once.
+func TestStrokeOverlapBlendsOnce(t *testing.T) {
+	once := newCanvas(&codec.Stroke{Width: 3, Pts: [][2]int{{20, 20}, {40, 20}}})
+	twice := newCanvas(&codec.Stroke{Width: 3, Pts: [][2]int{{20, 20}, {40, 20}, {20, 20}}})
+	for y := range 64 {
+		for x := range 64 {
+			if a, b := once.RGBAAt(x, y), twice.RGBAAt(x, y); a != b {
+				t.Fatalf("pixel (%d, %d) = %v retraced, %v once", x, y, b, a)
+			}
+		}
+	}
+}
+
+func TestStrokeBounds(t *testing.T) {
+	dst := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	Fill(dst, white)
+	r := Stroke(dst, &codec.Stroke{Width: 4, Pts: [][2]int{{10, 10}, {20, 30}}})
+	if !r.Eq(image.Rect(7, 7, 23, 33)) {
+		t.Errorf("changed rectangle = %v", r)
+	}
+	for y := range 64 {
+		for x := range 64 {
+			if !(image.Point{x, y}).In(r) && dst.RGBAAt(x, y) != white {
+				t.Fatalf("pixel (%d, %d) outside %v changed", x, y, r)
+			}
+		}
+	}
+	if r := Stroke(dst, &codec.Stroke{Width: 4, Pts: [][2]int{{-50, -50}, {-20, -40}}}); !r.Empty() {
+		t.Errorf("stroke off the canvas changed %v", r)
+	}
+	if r := Stroke(dst, &codec.Stroke{Width: 0, Pts: [][2]int{{10, 10}, {20, 10}}}); !r.Empty() {
+		t.Errorf("zero-width stroke changed %v", r)
+	}
+}
+
+func TestClearAndFill(t *testing.T) {
+	bg := color.RGBA{200, 210, 220, 255}
+	stroke := &codec.Stroke{Width: 8, Pts: [][2]int{{5, 5}, {50, 50}}}
+	tests := []struct {
+		name string
+		cmds []codec.Cmd
+		want color.RGBA
+	}{
+		{"clear restores the background", []codec.Cmd{codec.Fill{R: 1}, stroke, codec.Clear{}}, bg},
+		{"fill covers strokes", []codec.Cmd{stroke, codec.Fill{R: 9, G: 8, B: 7}}, color.RGBA{9, 8, 7, 255}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dst := image.NewRGBA(image.Rect(0, 0, 64, 64))
+			Replay(dst, bg, tt.cmds)
+			for y := range 64 {
+				for x := range 64 {
+					if got := dst.RGBAAt(x, y); got != tt.want {
+						t.Fatalf("pixel (%d, %d) = %v, want %v", x, y, got, tt.want)
+					}
+				}
+			}
+		})
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	if r := Cmd(dst, bg, codec.Clear{}); r != dst.Bounds() {
+		t.Errorf("Clear changed %v, want the whole canvas", r)
+	}
+}
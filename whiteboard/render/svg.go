@@ -0,0 +1,69 @@
+package render
+
+import (
+	"bufio"
+	"fmt"
+	"image/color"
+	"io"
+	"strconv"
+
+	"github.com/raydac/bkbin2wav/codec"
+)
+
+// SVG writes cmds as a standalone SVG document of the given canvas size: a
+// background rectangle in bg, a <path> with round caps and joins per stroke, a
+// <circle> per one-point stroke and a full-canvas <rect> per clear or fill.
+// Commands hidden behind a later clear or fill are written too; callers that
+// want a minimal document pass a trimmed history.
+func SVG(w io.Writer, width, height int, bg color.RGBA, cmds []codec.Cmd) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d">`+"\n",
+		width, height, width, height)
+	svgRect(bw, width, height, bg)
+	for _, cmd := range cmds {
+		switch c := cmd.(type) {
+		case *codec.Stroke:
+			svgStroke(bw, c)
+		case codec.Clear:
+			svgRect(bw, width, height, bg)
+		case codec.Fill:
+			svgRect(bw, width, height, color.RGBA{c.R, c.G, c.B, 255})
+		}
+	}
+	bw.WriteString("</svg>\n")
+	return bw.Flush()
+}
+
+func svgRect(w *bufio.Writer, width, height int, c color.RGBA) {
+	fmt.Fprintf(w, `<rect width="%d" height="%d" fill="%s"/>`+"\n", width, height, HexColor(c))
+}
+
+func svgStroke(w *bufio.Writer, s *codec.Stroke) {
+	if len(s.Pts) == 0 {
+		return
+	}
+	col := HexColor(color.RGBA{s.R, s.G, s.B, 255})
+	if len(s.Pts) == 1 {
+		// Same dot as Stroke draws: radius Width/2 rounded down.
+		if r := s.Width / 2; r > 0 {
+			fmt.Fprintf(w, `<circle cx="%d" cy="%d" r="%d" fill="%s"/>`+"\n", s.Pts[0][0], s.Pts[0][1], r, col)
+		}
+		return
+	}
+	fmt.Fprintf(w, `<path fill="none" stroke="%s" stroke-width="%d" stroke-linecap="round" stroke-linejoin="round" d="M%d %d`,
+		col, s.Width, s.Pts[0][0], s.Pts[0][1])
+	var buf []byte
+	for _, p := range s.Pts[1:] {
+		buf = append(buf[:0], 'L')
+		buf = strconv.AppendInt(buf, int64(p[0]), 10)
+		buf = append(buf, ' ')
+		buf = strconv.AppendInt(buf, int64(p[1]), 10)
+		w.Write(buf)
+	}
+	w.WriteString(`"/>` + "\n")
+}
+
+// HexColor formats c as #rrggbb, ignoring alpha.
+func HexColor(c color.RGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
@@ -0,0 +1,118 @@
+package render
+
+import (
+	"bytes"
+	"encoding/xml"
+	"image/color"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/raydac/bkbin2wav/codec"
+)
+
+// svgElement is an element of an SVG document with its attributes, in
+// document order.
+type svgElement struct {
+	Name  string
+	Attrs map[string]string
+}
+
+// parseSVG parses doc as XML and lists its elements, failing the test when the
+// document is not well-formed.
+func parseSVG(t *testing.T, doc []byte) []svgElement {
+	t.Helper()
+	var els []svgElement
+	d := xml.NewDecoder(bytes.NewReader(doc))
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			return els
+		}
+		if err != nil {
+			t.Fatalf("SVG is not well-formed: %v\n%s", err, doc)
+		}
+		if se, ok := tok.(xml.StartElement); ok {
+			el := svgElement{Name: se.Name.Local, Attrs: map[string]string{}}
+			for _, a := range se.Attr {
+				el.Attrs[a.Name.Local] = a.Value
+			}
+			els = append(els, el)
+		}
+	}
+}
+
+func TestSVG(t *testing.T) {
+	bg := color.RGBA{1, 2, 3, 255}
+	rect := func(fill string) svgElement {
+		return svgElement{"rect", map[string]string{"width": "320", "height": "200", "fill": fill}}
+	}
+	tests := []struct {
+		name string
+		cmds []codec.Cmd
+		want []svgElement
+	}{
+		{"empty", nil, nil},
+		{"line", []codec.Cmd{&codec.Stroke{R: 0xAB, G: 0x0C, B: 0xFF, Width: 255, Pts: [][2]int{{-5, 7}, {300, -10}, {12, 2047}}}},
+			[]svgElement{{"path", map[string]string{
+				"fill": "none", "stroke": "#ab0cff", "stroke-width": "255",
+				"stroke-linecap": "round", "stroke-linejoin": "round", "d": "M-5 7L300 -10L12 2047",
+			}}}},
+		{"dot", []codec.Cmd{&codec.Stroke{G: 255, Width: 5, Pts: [][2]int{{10, 20}}}},
+			[]svgElement{{"circle", map[string]string{"cx": "10", "cy": "20", "r": "2", "fill": "#00ff00"}}}},
+		{"invisible", []codec.Cmd{
+			&codec.Stroke{Width: 1, Pts: [][2]int{{10, 20}}},
+			&codec.Stroke{Width: 3},
+		}, nil},
+		{"clear and fill", []codec.Cmd{codec.Fill{R: 0x80, G: 0x40, B: 0x20}, codec.Clear{}},
+			[]svgElement{rect("#804020"), rect("#010203")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := SVG(&buf, 320, 200, bg, tt.cmds); err != nil {
+				t.Fatal(err)
+			}
+			els := parseSVG(t, buf.Bytes())
+			root := svgElement{"svg", map[string]string{
+				"xmlns": "http://www.w3.org/2000/svg", "width": "320", "height": "200", "viewBox": "0 0 320 200",
+			}}
+			want := append([]svgElement{root, rect("#010203")}, tt.want...)
+			if !reflect.DeepEqual(els, want) {
+				t.Errorf("elements =\n%v\nwant\n%v", els, want)
+			}
+		})
+	}
+}
+
+func TestSVGLongStroke(t *testing.T) {
+	pts := make([][2]int, 5000)
+	for i := range pts {
+		pts[i] = [2]int{i % 640, i / 640}
+	}
+	var buf bytes.Buffer
+	if err := SVG(&buf, 640, 480, white, []codec.Cmd{&codec.Stroke{Width: 2, Pts: pts}}); err != nil {
+		t.Fatal(err)
+	}
+	els := parseSVG(t, buf.Bytes())
+	if got := strings.Count(els[len(els)-1].Attrs["d"], "L"); got != len(pts)-1 {
+		t.Errorf("path has %d line segments, want %d", got, len(pts)-1)
+	}
+}
+
+func TestHexColor(t *testing.T) {
+	tests := []struct {
+		c    color.RGBA
+		want string
+	}{
+		{color.RGBA{0, 0, 0, 255}, "#000000"},
+		{color.RGBA{255, 255, 255, 0}, "#ffffff"},
+		{color.RGBA{0x0A, 0xBC, 0x01, 128}, "#0abc01"},
+	}
+	for _, tt := range tests {
+		if got := HexColor(tt.c); got != tt.want {
+			t.Errorf("HexColor(%v) = %q, want %q", tt.c, got, tt.want)
+		}
+	}
+}
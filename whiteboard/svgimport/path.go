@@ -0,0 +1,373 @@
+package svgimport
+
+import (
+	"math"
+	"strconv"
+)
+
+// pt is a point, in user units or canvas pixels depending on context.
+type pt [2]float64
+
+// flattener turns path segments given in user units into polylines in canvas
+// pixels. Curves are flattened after transforming their control points, so
+// the tolerance holds on the canvas whatever the transform.
+type flattener struct {
+	m        matrix
+	tol      float64
+	subpaths [][]pt // finished subpaths, canvas pixels
+	cur      []pt   // subpath being built, canvas pixels
+	start    pt     // first point of the current subpath, user units
+	pos      pt     // current point, user units
+}
+
+func (f *flattener) moveTo(p pt) {
+	f.flush()
+	f.start, f.pos = p, p
+	f.cur = append(f.cur, f.m.apply(p))
+}
+
+func (f *flattener) lineTo(p pt) {
+	f.begin()
+	f.pos = p
+	f.cur = append(f.cur, f.m.apply(p))
+}
+
+// begin starts a subpath at the current point when the last one was closed
+// without a moveto following.
+func (f *flattener) begin() {
+	if len(f.cur) == 0 {
+		f.cur = append(f.cur, f.m.apply(f.pos))
+	}
+}
+
+// closePath draws back to the start of the subpath and finishes it; drawing
+// on without a moveto continues from that start point.
+func (f *flattener) closePath() {
+	if len(f.cur) == 0 {
+		return
+	}
+	f.lineTo(f.start)
+	f.flush()
+}
+
+// flush finishes the current subpath. A lone moveto draws nothing and is
+// dropped.
+func (f *flattener) flush() {
+	if len(f.cur) > 1 {
+		f.subpaths = append(f.subpaths, f.cur)
+	}
+	f.cur = nil
+}
+
+// cubicTo flattens a cubic Bézier into the number of segments Wang's formula
+// gives for the tolerance.
+func (f *flattener) cubicTo(c1, c2, p pt) {
+	f.begin()
+	p0, p1, p2, p3 := f.m.apply(f.pos), f.m.apply(c1), f.m.apply(c2), f.m.apply(p)
+	dd := math.Max(
+		math.Hypot(p0[0]-2*p1[0]+p2[0], p0[1]-2*p1[1]+p2[1]),
+		math.Hypot(p1[0]-2*p2[0]+p3[0], p1[1]-2*p2[1]+p3[1]))
+	n := segments(math.Sqrt(0.75 * dd / f.tol))
+	for i := 1; i < n; i++ {
+		t := float64(i) / float64(n)
+		u := 1 - t
+		a, b, c, d := u*u*u, 3*u*u*t, 3*u*t*t, t*t*t
+		f.cur = append(f.cur, pt{
+			a*p0[0] + b*p1[0] + c*p2[0] + d*p3[0],
+			a*p0[1] + b*p1[1] + c*p2[1] + d*p3[1],
+		})
+	}
+	f.lineTo(p)
+}
+
+// quadTo flattens a quadratic Bézier like cubicTo.
+func (f *flattener) quadTo(c, p pt) {
+	f.begin()
+	p0, p1, p2 := f.m.apply(f.pos), f.m.apply(c), f.m.apply(p)
+	dd := math.Hypot(p0[0]-2*p1[0]+p2[0], p0[1]-2*p1[1]+p2[1])
+	n := segments(math.Sqrt(0.25 * dd / f.tol))
+	for i := 1; i < n; i++ {
+		t := float64(i) / float64(n)
+		u := 1 - t
+		a, b, d := u*u, 2*u*t, t*t
+		f.cur = append(f.cur, pt{
+			a*p0[0] + b*p1[0] + d*p2[0],
+			a*p0[1] + b*p1[1] + d*p2[1],
+		})
+	}
+	f.lineTo(p)
+}
+
+// arcTo flattens an elliptical arc given in SVG endpoint form, converting it
+// to centre form as in the SVG implementation notes (F.6.5 and F.6.6).
+func (f *flattener) arcTo(rx, ry, rotation float64, large, sweep bool, p pt) {
+	rx, ry = math.Abs(rx), math.Abs(ry)
+	if rx == 0 || ry == 0 || p == f.pos {
+		f.lineTo(p)
+		return
+	}
+	f.begin()
+	sinPhi, cosPhi := math.Sincos(rotation * math.Pi / 180)
+	dx, dy := (f.pos[0]-p[0])/2, (f.pos[1]-p[1])/2
+	x1 := cosPhi*dx + sinPhi*dy
+	y1 := -sinPhi*dx + cosPhi*dy
+	if l := x1*x1/(rx*rx) + y1*y1/(ry*ry); l > 1 {
+		rx, ry = rx*math.Sqrt(l), ry*math.Sqrt(l)
+	}
+	num := rx*rx*ry*ry - rx*rx*y1*y1 - ry*ry*x1*x1
+	den := rx*rx*y1*y1 + ry*ry*x1*x1
+	k := math.Sqrt(math.Max(0, num/den))
+	if large == sweep {
+		k = -k
+	}
+	cx1, cy1 := k*rx*y1/ry, -k*ry*x1/rx
+	cx := cosPhi*cx1 - sinPhi*cy1 + (f.pos[0]+p[0])/2
+	cy := sinPhi*cx1 + cosPhi*cy1 + (f.pos[1]+p[1])/2
+	theta := math.Atan2((y1-cy1)/ry, (x1-cx1)/rx)
+	delta := math.Atan2((-y1-cy1)/ry, (-x1-cx1)/rx) - theta
+	if sweep && delta < 0 {
+		delta += 2 * math.Pi
+	} else if !sweep && delta > 0 {
+		delta -= 2 * math.Pi
+	}
+
+	// The chord of a step dθ strays r(1-cos(dθ/2)) from the arc.
+	r := math.Max(rx, ry) * f.m.maxScale()
+	n := 1
+	if r > f.tol {
+		n = segments(math.Abs(delta) / (2 * math.Acos(1-f.tol/r)))
+	}
+	for i := 1; i < n; i++ {
+		sin, cos := math.Sincos(theta + delta*float64(i)/float64(n))
+		f.cur = append(f.cur, f.m.apply(pt{
+			cx + rx*cos*cosPhi - ry*sin*sinPhi,
+			cy + rx*cos*sinPhi + ry*sin*cosPhi,
+		}))
+	}
+	f.lineTo(p)
+}
+
+// segments rounds a segment count up, within 1..maxSegments.
+func segments(v float64) int {
+	if !(v >= 1) {
+		return 1
+	}
+	return int(math.Ceil(math.Min(v, maxSegments)))
+}
+
+// path flattens SVG path data. As the SVG error handling rules ask, drawing
+// stops at the first malformed command and everything before it is kept.
+func (f *flattener) path(d string) {
+	sc := scanner{s: d}
+	var cmd byte
+	var ctrl pt      // last control point, for S and T reflection
+	var prevCmd byte // upper-case command drawn last
+	for {
+		sc.skipSpace()
+		if sc.i >= len(sc.s) {
+			break
+		}
+		if c := sc.s[sc.i]; isPathCommand(c) {
+			cmd = c
+			sc.i++
+		} else if cmd == 0 || cmd == 'Z' || cmd == 'z' {
+			return
+		}
+		rel := cmd >= 'a'
+		abs := func(p pt) pt {
+			if rel {
+				return pt{f.pos[0] + p[0], f.pos[1] + p[1]}
+			}
+			return p
+		}
+		upper := cmd &^ 0x20
+		switch upper {
+		case 'M':
+			p, ok := sc.pair()
+			if !ok {
+				return
+			}
+			f.moveTo(abs(p))
+			// Further coordinate pairs are implicit linetos.
+			if rel {
+				cmd = 'l'
+			} else {
+				cmd = 'L'
+			}
+		case 'L':
+			p, ok := sc.pair()
+			if !ok {
+				return
+			}
+			f.lineTo(abs(p))
+		case 'H', 'V':
+			v, ok := sc.number()
+			if !ok {
+				return
+			}
+			p := f.pos
+			i := 0
+			if upper == 'V' {
+				i = 1
+			}
+			if rel {
+				p[i] += v
+			} else {
+				p[i] = v
+			}
+			f.lineTo(p)
+		case 'C':
+			c1, ok1 := sc.pair()
+			c2, ok2 := sc.pair()
+			p, ok3 := sc.pair()
+			if !ok1 || !ok2 || !ok3 {
+				return
+			}
+			c1, c2, p = abs(c1), abs(c2), abs(p)
+			f.cubicTo(c1, c2, p)
+			ctrl = c2
+		case 'S':
+			c2, ok1 := sc.pair()
+			p, ok2 := sc.pair()
+			if !ok1 || !ok2 {
+				return
+			}
+			c1 := f.pos
+			if prevCmd == 'C' || prevCmd == 'S' {
+				c1 = pt{2*f.pos[0] - ctrl[0], 2*f.pos[1] - ctrl[1]}
+			}
+			c2, p = abs(c2), abs(p)
+			f.cubicTo(c1, c2, p)
+			ctrl = c2
+		case 'Q':
+			c, ok1 := sc.pair()
+			p, ok2 := sc.pair()
+			if !ok1 || !ok2 {
+				return
+			}
+			c, p = abs(c), abs(p)
+			f.quadTo(c, p)
+			ctrl = c
+		case 'T':
+			p, ok := sc.pair()
+			if !ok {
+				return
+			}
+			c := f.pos
+			if prevCmd == 'Q' || prevCmd == 'T' {
+				c = pt{2*f.pos[0] - ctrl[0], 2*f.pos[1] - ctrl[1]}
+			}
+			p = abs(p)
+			f.quadTo(c, p)
+			ctrl = c
+		case 'A':
+			rx, ok1 := sc.number()
+			ry, ok2 := sc.number()
+			rot, ok3 := sc.number()
+			large, ok4 := sc.flag()
+			sweep, ok5 := sc.flag()
+			p, ok6 := sc.pair()
+			if !ok1 || !ok2 || !ok3 || !ok4 || !ok5 || !ok6 {
+				return
+			}
+			f.arcTo(rx, ry, rot, large, sweep, abs(p))
+		case 'Z':
+			f.closePath()
+			f.pos = f.start
+		}
+		prevCmd = upper
+	}
+}
+
+func isPathCommand(c byte) bool {
+	switch c &^ 0x20 {
+	case 'M', 'L', 'H', 'V', 'C', 'S', 'Q', 'T', 'A', 'Z':
+		return true
+	}
+	return false
+}
+
+// scanner reads the numbers of path data, point lists and viewBox and
+// transform arguments, which may be separated by whitespace, a comma or
+// nothing at all when the next number starts with a sign or a second dot.
+type scanner struct {
+	s string
+	i int
+}
+
+func (sc *scanner) skipSpace() {
+	for sc.i < len(sc.s) {
+		switch sc.s[sc.i] {
+		case ' ', '\t', '\r', '\n', '\f':
+			sc.i++
+		default:
+			return
+		}
+	}
+}
+
+// skipSep skips whitespace and at most one comma.
+func (sc *scanner) skipSep() {
+	sc.skipSpace()
+	if sc.i < len(sc.s) && sc.s[sc.i] == ',' {
+		sc.i++
+		sc.skipSpace()
+	}
+}
+
+func (sc *scanner) number() (float64, bool) {
+	sc.skipSep()
+	start, i := sc.i, sc.i
+	s := sc.s
+	if i < len(s) && (s[i] == '+' || s[i] == '-') {
+		i++
+	}
+	digits := 0
+	for ; i < len(s) && s[i] >= '0' && s[i] <= '9'; i++ {
+		digits++
+	}
+	if i < len(s) && s[i] == '.' {
+		i++
+		for ; i < len(s) && s[i] >= '0' && s[i] <= '9'; i++ {
+			digits++
+		}
+	}
+	if digits == 0 {
+		return 0, false
+	}
+	if i < len(s) && (s[i] == 'e' || s[i] == 'E') {
+		j := i + 1
+		if j < len(s) && (s[j] == '+' || s[j] == '-') {
+			j++
+		}
+		if j < len(s) && s[j] >= '0' && s[j] <= '9' {
+			for i = j; i < len(s) && s[i] >= '0' && s[i] <= '9'; i++ {
+			}
+		}
+	}
+	v, err := strconv.ParseFloat(s[start:i], 64)
+	if err != nil {
+		return 0, false
+	}
+	sc.i = i
+	return v, true
+}
+
+func (sc *scanner) pair() (pt, bool) {
+	x, ok := sc.number()
+	if !ok {
+		return pt{}, false
+	}
+	y, ok := sc.number()
+	return pt{x, y}, ok
+}
+
+// flag reads an arc flag, a single 0 or 1 that needs no separator.
+func (sc *scanner) flag() (bool, bool) {
+	sc.skipSep()
+	if sc.i >= len(sc.s) || (sc.s[sc.i] != '0' && sc.s[sc.i] != '1') {
+		return false, false
+	}
+	sc.i++
+	return sc.s[sc.i-1] == '1', true
+}
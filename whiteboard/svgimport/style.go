@@ -0,0 +1,337 @@
+package svgimport
+
+import (
+	"image/color"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// style is the inherited state of an element: its transform to canvas
+// coordinates and the presentation properties Import cares about.
+type style struct {
+	m      matrix
+	stroke paint
+	fill   paint
+	width  float64    // stroke-width in user units
+	color  color.RGBA // value of currentColor
+}
+
+// defaultStyle holds the SVG initial values: black fill, no stroke.
+var defaultStyle = style{
+	m:      identity,
+	stroke: paint{none: true},
+	fill:   paint{c: black},
+	width:  1,
+	color:  black,
+}
+
+var black = color.RGBA{0, 0, 0, 255}
+
+// apply updates st from an element's presentation attributes, its style
+// attribute (which wins) and its transform. It returns false when the element
+// and its children are not rendered at all.
+func (st *style) apply(attrs map[string]string) bool {
+	props := make(map[string]string)
+	for _, name := range []string{"stroke", "fill", "stroke-width", "color", "display", "opacity", "stroke-opacity", "fill-opacity"} {
+		if v, ok := attrs[name]; ok {
+			props[name] = v
+		}
+	}
+	for _, decl := range strings.Split(attrs["style"], ";") {
+		name, value, ok := strings.Cut(decl, ":")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(value), "!important"))
+		props[strings.TrimSpace(name)] = value
+	}
+	if strings.TrimSpace(props["display"]) == "none" || isZero(props["opacity"]) {
+		return false
+	}
+	if v, ok := props["color"]; ok {
+		if c, ok := parseColor(v); ok {
+			st.color = c
+		}
+	}
+	if v, ok := props["stroke"]; ok {
+		st.stroke = parsePaint(v, st.stroke)
+	}
+	if v, ok := props["fill"]; ok {
+		st.fill = parsePaint(v, st.fill)
+	}
+	if v, ok := props["stroke-width"]; ok {
+		if w, ok := parseLength(v); ok && w >= 0 {
+			st.width = w
+		}
+	}
+	if isZero(props["stroke-opacity"]) {
+		st.stroke = paint{none: true}
+	}
+	if isZero(props["fill-opacity"]) {
+		st.fill = paint{none: true}
+	}
+	if v, ok := attrs["transform"]; ok {
+		st.m = st.m.mul(parseTransform(v))
+	}
+	return true
+}
+
+func isZero(s string) bool {
+	v, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	return err == nil && v <= 0
+}
+
+// paint is a stroke or fill value.
+type paint struct {
+	none    bool
+	current bool // currentColor
+	c       color.RGBA
+}
+
+func (p paint) resolve(current color.RGBA) color.RGBA {
+	if p.current {
+		return current
+	}
+	return p.c
+}
+
+// parsePaint parses a stroke or fill value, keeping inherited when the value
+// is "inherit" or not understood. Gradients and patterns are drawn in their
+// fallback colour, or black when there is none.
+func parsePaint(s string, inherited paint) paint {
+	s = strings.TrimSpace(s)
+	switch s {
+	case "none", "transparent":
+		return paint{none: true}
+	case "currentColor":
+		return paint{current: true}
+	case "inherit":
+		return inherited
+	}
+	if strings.HasPrefix(s, "url(") {
+		if _, fallback, ok := strings.Cut(s, ")"); ok && strings.TrimSpace(fallback) != "" {
+			return parsePaint(fallback, paint{c: black})
+		}
+		return paint{c: black}
+	}
+	if c, ok := parseColor(s); ok {
+		return paint{c: c}
+	}
+	return inherited
+}
+
+// parseColor parses #rgb, #rrggbb (alpha digits are ignored), rgb()/rgba()
+// and the common colour keywords.
+func parseColor(s string) (color.RGBA, bool) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if strings.HasPrefix(s, "#") {
+		hex := s[1:]
+		switch len(hex) {
+		case 3, 4:
+			v, err := strconv.ParseUint(hex[:3], 16, 16)
+			if err != nil {
+				return color.RGBA{}, false
+			}
+			r, g, b := byte(v>>8&0xF), byte(v>>4&0xF), byte(v&0xF)
+			return color.RGBA{r * 17, g * 17, b * 17, 255}, true
+		case 6, 8:
+			v, err := strconv.ParseUint(hex[:6], 16, 32)
+			if err != nil {
+				return color.RGBA{}, false
+			}
+			return color.RGBA{byte(v >> 16), byte(v >> 8), byte(v), 255}, true
+		}
+		return color.RGBA{}, false
+	}
+	if args, ok := strings.CutPrefix(s, "rgb"); ok {
+		args = strings.TrimPrefix(args, "a")
+		if !strings.HasPrefix(args, "(") || !strings.HasSuffix(args, ")") {
+			return color.RGBA{}, false
+		}
+		parts := strings.FieldsFunc(args[1:len(args)-1], func(r rune) bool {
+			return r == ',' || r == ' ' || r == '/'
+		})
+		if len(parts) < 3 {
+			return color.RGBA{}, false
+		}
+		var c [3]byte
+		for i := range c {
+			p := parts[i]
+			max := 255.0
+			if strings.HasSuffix(p, "%") {
+				p, max = p[:len(p)-1], 100
+			}
+			v, err := strconv.ParseFloat(p, 64)
+			if err != nil {
+				return color.RGBA{}, false
+			}
+			c[i] = byte(math.Round(math.Max(0, math.Min(1, v/max)) * 255))
+		}
+		return color.RGBA{c[0], c[1], c[2], 255}, true
+	}
+	c, ok := namedColors[s]
+	return c, ok
+}
+
+var namedColors = map[string]color.RGBA{
+	"black":     {0, 0, 0, 255},
+	"white":     {255, 255, 255, 255},
+	"red":       {255, 0, 0, 255},
+	"green":     {0, 128, 0, 255},
+	"lime":      {0, 255, 0, 255},
+	"blue":      {0, 0, 255, 255},
+	"yellow":    {255, 255, 0, 255},
+	"cyan":      {0, 255, 255, 255},
+	"aqua":      {0, 255, 255, 255},
+	"magenta":   {255, 0, 255, 255},
+	"fuchsia":   {255, 0, 255, 255},
+	"gray":      {128, 128, 128, 255},
+	"grey":      {128, 128, 128, 255},
+	"darkgray":  {169, 169, 169, 255},
+	"darkgrey":  {169, 169, 169, 255},
+	"lightgray": {211, 211, 211, 255},
+	"lightgrey": {211, 211, 211, 255},
+	"silver":    {192, 192, 192, 255},
+	"maroon":    {128, 0, 0, 255},
+	"olive":     {128, 128, 0, 255},
+	"teal":      {0, 128, 128, 255},
+	"navy":      {0, 0, 128, 255},
+	"purple":    {128, 0, 128, 255},
+	"orange":    {255, 165, 0, 255},
+	"brown":     {165, 42, 42, 255},
+	"pink":      {255, 192, 203, 255},
+	"gold":      {255, 215, 0, 255},
+	"indigo":    {75, 0, 130, 255},
+	"violet":    {238, 130, 238, 255},
+	"darkred":   {139, 0, 0, 255},
+	"darkgreen": {0, 100, 0, 255},
+	"darkblue":  {0, 0, 139, 255},
+}
+
+// parseLength parses a length in user units (px). Absolute units are
+// converted at 96 dpi; percentages and font-relative units are not supported.
+func parseLength(s string) (float64, bool) {
+	s = strings.TrimSpace(s)
+	unit := 1.0
+	for _, u := range []struct {
+		suffix string
+		scale  float64
+	}{{"px", 1}, {"pt", 96.0 / 72}, {"pc", 16}, {"mm", 96 / 25.4}, {"cm", 96 / 2.54}, {"in", 96}} {
+		if strings.HasSuffix(s, u.suffix) {
+			s, unit = strings.TrimSpace(s[:len(s)-len(u.suffix)]), u.scale
+			break
+		}
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsInf(v, 0) || math.IsNaN(v) {
+		return 0, false
+	}
+	return v * unit, true
+}
+
+// parseViewBox parses "min-x min-y width height"; both sizes must be positive.
+func parseViewBox(s string) ([4]float64, bool) {
+	var vb [4]float64
+	sc := scanner{s: s}
+	for i := range vb {
+		v, ok := sc.number()
+		if !ok {
+			return vb, false
+		}
+		vb[i] = v
+	}
+	return vb, vb[2] > 0 && vb[3] > 0
+}
+
+// matrix is an affine transform {a, b, c, d, e, f} as in SVG: a point (x, y)
+// maps to (a*x + c*y + e, b*x + d*y + f).
+type matrix [6]float64
+
+var identity = matrix{1, 0, 0, 1, 0, 0}
+
+func translate(x, y float64) matrix { return matrix{1, 0, 0, 1, x, y} }
+func scale(x, y float64) matrix     { return matrix{x, 0, 0, y, 0, 0} }
+
+// mul returns the transform that applies n first, then m.
+func (m matrix) mul(n matrix) matrix {
+	return matrix{
+		m[0]*n[0] + m[2]*n[1],
+		m[1]*n[0] + m[3]*n[1],
+		m[0]*n[2] + m[2]*n[3],
+		m[1]*n[2] + m[3]*n[3],
+		m[0]*n[4] + m[2]*n[5] + m[4],
+		m[1]*n[4] + m[3]*n[5] + m[5],
+	}
+}
+
+func (m matrix) apply(p pt) pt {
+	return pt{m[0]*p[0] + m[2]*p[1] + m[4], m[1]*p[0] + m[3]*p[1] + m[5]}
+}
+
+// scale is the factor stroke widths grow by: the geometric mean of the axis
+// scales.
+func (m matrix) scale() float64 {
+	return math.Sqrt(math.Abs(m[0]*m[3] - m[1]*m[2]))
+}
+
+// maxScale is the largest factor any length grows by under m, the larger
+// singular value of its linear part.
+func (m matrix) maxScale() float64 {
+	a, b, c, d := m[0], m[1], m[2], m[3]
+	s := a*a + b*b + c*c + d*d
+	t := math.Hypot(a*a+b*b-c*c-d*d, 2*(a*c+b*d))
+	return math.Sqrt((s + t) / 2)
+}
+
+// parseTransform parses a transform list such as "translate(10 20) rotate(45)".
+// Parsing stops at the first malformed entry, keeping the ones before it.
+func parseTransform(s string) matrix {
+	m := identity
+	for {
+		s = strings.TrimLeft(s, " \t\r\n,")
+		open := strings.IndexByte(s, '(')
+		end := strings.IndexByte(s, ')')
+		if open < 0 || end < open {
+			return m
+		}
+		name := strings.TrimSpace(s[:open])
+		sc := scanner{s: s[open+1 : end]}
+		var args []float64
+		for {
+			v, ok := sc.number()
+			if !ok {
+				break
+			}
+			args = append(args, v)
+		}
+		s = s[end+1:]
+
+		var t matrix
+		switch {
+		case name == "matrix" && len(args) == 6:
+			copy(t[:], args)
+		case name == "translate" && len(args) == 1:
+			t = translate(args[0], 0)
+		case name == "translate" && len(args) == 2:
+			t = translate(args[0], args[1])
+		case name == "scale" && len(args) == 1:
+			t = scale(args[0], args[0])
+		case name == "scale" && len(args) == 2:
+			t = scale(args[0], args[1])
+		case name == "rotate" && (len(args) == 1 || len(args) == 3):
+			sin, cos := math.Sincos(args[0] * math.Pi / 180)
+			t = matrix{cos, sin, -sin, cos, 0, 0}
+			if len(args) == 3 {
+				t = translate(args[1], args[2]).mul(t).mul(translate(-args[1], -args[2]))
+			}
+		case name == "skewX" && len(args) == 1:
+			t = matrix{1, 0, math.Tan(args[0] * math.Pi / 180), 1, 0, 0}
+		case name == "skewY" && len(args) == 1:
+			t = matrix{1, math.Tan(args[0] * math.Pi / 180), 0, 1, 0, 0}
+		default:
+			return m
+		}
+		m = m.mul(t)
+	}
+}
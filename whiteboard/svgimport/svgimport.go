@@ -0,0 +1,334 @@
+// Package svgimport turns the shapes of an SVG document into whiteboard
+// strokes, so diagrams drawn elsewhere can be annotated on the board. Only the
+// geometry survives: every path, line, polyline, polygon, rect, circle and
+// ellipse becomes one stroke per subpath, with curves and arcs flattened into
+// short line segments. Text, images, <use> references, gradients and CSS style
+// sheets are ignored. It has no browser dependencies, like package codec.
+package svgimport
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"image/color"
+	"io"
+	"math"
+	"strings"
+
+	"github.com/raydac/bkbin2wav/codec"
+)
+
+// ErrNotSVG is returned by Import when the document element is not <svg>.
+var ErrNotSVG = errors.New("svgimport: not an SVG document")
+
+// DefaultTolerance is the flattening tolerance used when Options.Tolerance is
+// zero: the most a flattened curve may stray from the real one, in canvas
+// pixels.
+const DefaultTolerance = 0.25
+
+// maxSegments caps the line segments a single curve or arc is flattened into,
+// whatever its size or the tolerance.
+const maxSegments = 1024
+
+// Options controls how a document is placed on the board.
+type Options struct {
+	// Width and Height are the canvas size. A document larger than the canvas
+	// is scaled down to fit it, keeping its aspect ratio; smaller documents
+	// keep their size. Zero disables fitting.
+	Width, Height int
+	Tolerance     float64 // see DefaultTolerance
+}
+
+// Import reads an SVG document and returns its shapes as strokes in canvas
+// coordinates, in document order. Stroked shapes keep their stroke colour and
+// width; shapes that are only filled are outlined in their fill colour with a
+// 1 pixel pen. Import fails with a *codec.LimitError rather than produce more
+// strokes or points than codec.DefaultLimits lets a share link carry.
+func Import(r io.Reader, opt Options) ([]*codec.Stroke, error) {
+	tol := opt.Tolerance
+	if tol <= 0 {
+		tol = DefaultTolerance
+	}
+	dec := xml.NewDecoder(r)
+	dec.Entity = xml.HTMLEntity
+	// Only attribute values matter and they are ASCII in practice, so any
+	// declared encoding is read as is.
+	dec.CharsetReader = func(_ string, in io.Reader) (io.Reader, error) { return in, nil }
+
+	imp := importer{tol: tol, limits: codec.DefaultLimits}
+	var stack []style
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("svgimport: %w", err)
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			name := t.Name.Local
+			if stack == nil && name != "svg" {
+				return nil, ErrNotSVG
+			}
+			if skipped[name] {
+				if err := dec.Skip(); err != nil {
+					return nil, fmt.Errorf("svgimport: %w", err)
+				}
+				continue
+			}
+			var st style
+			if stack == nil {
+				st = defaultStyle
+			} else {
+				st = stack[len(stack)-1]
+			}
+			attrs := attrMap(t.Attr)
+			if !st.apply(attrs) {
+				if err := dec.Skip(); err != nil {
+					return nil, fmt.Errorf("svgimport: %w", err)
+				}
+				continue
+			}
+			if name == "svg" {
+				st.m = st.m.mul(imp.viewport(attrs, stack == nil, opt))
+			}
+			stack = append(stack, st)
+			if err := imp.shape(name, attrs, &st); err != nil {
+				return nil, err
+			}
+		case xml.EndElement:
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+			if len(stack) == 0 {
+				return imp.strokes, nil
+			}
+		}
+	}
+	if stack == nil {
+		return nil, ErrNotSVG
+	}
+	return imp.strokes, nil
+}
+
+// skipped lists elements whose content is never drawn directly.
+var skipped = map[string]bool{
+	"defs": true, "symbol": true, "clipPath": true, "mask": true, "marker": true,
+	"pattern": true, "linearGradient": true, "radialGradient": true, "filter": true,
+	"style": true, "script": true, "title": true, "desc": true, "metadata": true,
+	"text": true, "foreignObject": true, "image": true, "use": true,
+}
+
+// importer collects the strokes of one document.
+type importer struct {
+	tol     float64
+	limits  codec.Limits
+	strokes []*codec.Stroke
+	points  int
+}
+
+// viewport returns the transform an <svg> element establishes: its x/y offset
+// (nested elements only) and the viewBox mapping. For the document element it
+// also scales the document down to fit the canvas.
+func (imp *importer) viewport(attrs map[string]string, root bool, opt Options) matrix {
+	w, wok := parseLength(attrs["width"])
+	h, hok := parseLength(attrs["height"])
+	vb, vbok := parseViewBox(attrs["viewBox"])
+	if !wok && vbok {
+		w, wok = vb[2], true
+	}
+	if !hok && vbok {
+		h, hok = vb[3], true
+	}
+	m := identity
+	if !root {
+		x, _ := parseLength(attrs["x"])
+		y, _ := parseLength(attrs["y"])
+		m = translate(x, y)
+	}
+	if vbok && wok && hok {
+		sx, sy := w/vb[2], h/vb[3]
+		tx, ty := 0.0, 0.0
+		// Only the default xMidYMid meet and "none" are honoured.
+		if !strings.HasPrefix(strings.TrimSpace(attrs["preserveAspectRatio"]), "none") {
+			s := math.Min(sx, sy)
+			tx, ty = (w-vb[2]*s)/2, (h-vb[3]*s)/2
+			sx, sy = s, s
+		}
+		m = m.mul(matrix{sx, 0, 0, sy, tx - vb[0]*sx, ty - vb[1]*sy})
+	}
+	if root && wok && hok && w > 0 && h > 0 && opt.Width > 0 && opt.Height > 0 &&
+		(w > float64(opt.Width) || h > float64(opt.Height)) {
+		f := math.Min(float64(opt.Width)/w, float64(opt.Height)/h)
+		m = scale(f, f).mul(m)
+	}
+	return m
+}
+
+// shape flattens a basic shape or path element into strokes; other elements
+// are ignored.
+func (imp *importer) shape(name string, attrs map[string]string, st *style) error {
+	f := flattener{m: st.m, tol: imp.tol}
+	num := func(key string) float64 {
+		v, _ := parseLength(attrs[key])
+		return v
+	}
+	switch name {
+	case "path":
+		f.path(attrs["d"])
+	case "line":
+		f.moveTo(pt{num("x1"), num("y1")})
+		f.lineTo(pt{num("x2"), num("y2")})
+	case "polyline", "polygon":
+		sc := scanner{s: attrs["points"]}
+		for i := 0; ; i++ {
+			p, ok := sc.pair()
+			if !ok {
+				break
+			}
+			if i == 0 {
+				f.moveTo(p)
+			} else {
+				f.lineTo(p)
+			}
+		}
+		if name == "polygon" {
+			f.closePath()
+		}
+	case "rect":
+		x, y, w, h := num("x"), num("y"), num("width"), num("height")
+		if w <= 0 || h <= 0 {
+			return nil
+		}
+		rx, rxok := parseLength(attrs["rx"])
+		ry, ryok := parseLength(attrs["ry"])
+		if !rxok {
+			rx = ry
+		}
+		if !ryok {
+			ry = rx
+		}
+		rx, ry = math.Min(math.Max(rx, 0), w/2), math.Min(math.Max(ry, 0), h/2)
+		if rx == 0 || ry == 0 {
+			f.moveTo(pt{x, y})
+			f.lineTo(pt{x + w, y})
+			f.lineTo(pt{x + w, y + h})
+			f.lineTo(pt{x, y + h})
+			f.closePath()
+			break
+		}
+		f.moveTo(pt{x + rx, y})
+		f.lineTo(pt{x + w - rx, y})
+		f.arcTo(rx, ry, 0, false, true, pt{x + w, y + ry})
+		f.lineTo(pt{x + w, y + h - ry})
+		f.arcTo(rx, ry, 0, false, true, pt{x + w - rx, y + h})
+		f.lineTo(pt{x + rx, y + h})
+		f.arcTo(rx, ry, 0, false, true, pt{x, y + h - ry})
+		f.lineTo(pt{x, y + ry})
+		f.arcTo(rx, ry, 0, false, true, pt{x + rx, y})
+		f.closePath()
+	case "circle", "ellipse":
+		cx, cy := num("cx"), num("cy")
+		rx, ry := num("r"), num("r")
+		if name == "ellipse" {
+			rx, ry = num("rx"), num("ry")
+		}
+		if rx <= 0 || ry <= 0 {
+			return nil
+		}
+		f.moveTo(pt{cx + rx, cy})
+		f.arcTo(rx, ry, 0, false, true, pt{cx - rx, cy})
+		f.arcTo(rx, ry, 0, false, true, pt{cx + rx, cy})
+		f.closePath()
+	default:
+		return nil
+	}
+	f.flush()
+	return imp.add(f.subpaths, st)
+}
+
+// add turns flattened subpaths into strokes painted as st says.
+func (imp *importer) add(subpaths [][]pt, st *style) error {
+	c, width, ok := st.pen()
+	if !ok {
+		return nil
+	}
+	for _, sp := range subpaths {
+		s := &codec.Stroke{R: c.R, G: c.G, B: c.B, Width: width}
+		for _, p := range sp {
+			q := [2]int{toCoord(p[0]), toCoord(p[1])}
+			if n := len(s.Pts); n > 0 && s.Pts[n-1] == q {
+				continue
+			}
+			s.Pts = append(s.Pts, q)
+		}
+		for _, piece := range imp.split(s) {
+			imp.points += len(piece.Pts)
+			if imp.limits.MaxPoints > 0 && imp.points > imp.limits.MaxPoints {
+				return &codec.LimitError{What: "points", Limit: imp.limits.MaxPoints}
+			}
+			imp.strokes = append(imp.strokes, piece)
+			if imp.limits.MaxCmds > 0 && len(imp.strokes) > imp.limits.MaxCmds {
+				return &codec.LimitError{What: "commands", Limit: imp.limits.MaxCmds}
+			}
+		}
+	}
+	return nil
+}
+
+// split cuts a stroke longer than a share link may carry into strokes of at
+// most MaxStrokePoints points, each going on from the last point of the one
+// before.
+func (imp *importer) split(s *codec.Stroke) []*codec.Stroke {
+	n := imp.limits.MaxStrokePoints
+	if n < 2 || len(s.Pts) <= n {
+		return []*codec.Stroke{s}
+	}
+	var pieces []*codec.Stroke
+	for pts := s.Pts; len(pts) > 1; pts = pts[min(n, len(pts))-1:] {
+		piece := *s
+		// A copy, as the pieces would share their end points otherwise.
+		piece.Pts = append([][2]int(nil), pts[:min(n, len(pts))]...)
+		pieces = append(pieces, &piece)
+	}
+	return pieces
+}
+
+// toCoord rounds a canvas coordinate, clamping the far-off values a
+// degenerate transform can produce.
+func toCoord(v float64) int {
+	const limit = 1 << 24
+	if math.IsNaN(v) {
+		return 0
+	}
+	return int(math.Round(math.Max(-limit, math.Min(limit, v))))
+}
+
+// pen returns the colour and width a shape is drawn with, and false when it
+// is neither stroked nor filled.
+func (st *style) pen() (color.RGBA, byte, bool) {
+	if !st.stroke.none && st.width > 0 {
+		w := math.Round(st.width * st.m.scale())
+		if !(w >= 1) {
+			w = 1
+		}
+		return st.stroke.resolve(st.color), byte(math.Min(w, 255)), true
+	}
+	if !st.fill.none {
+		return st.fill.resolve(st.color), 1, true
+	}
+	return color.RGBA{}, 0, false
+}
+
+func attrMap(attrs []xml.Attr) map[string]string {
+	m := make(map[string]string, len(attrs))
+	for _, a := range attrs {
+		// Namespaced attributes (xlink:href, inkscape:label, ...) never carry
+		// geometry or paint.
+		if a.Name.Space == "" {
+			m[a.Name.Local] = a.Value
+		}
+	}
+	return m
+}
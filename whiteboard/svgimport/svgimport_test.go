@@ -0,0 +1,348 @@
+package svgimport
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/raydac/bkbin2wav/codec"
+)
+
+// importBody imports body inside a 200×200 document.
+func importBody(t *testing.T, body string, opt Options) []*codec.Stroke {
+	t.Helper()
+	doc := `<svg xmlns="http://www.w3.org/2000/svg" width="200" height="200">` + body + `</svg>`
+	strokes, err := Import(strings.NewReader(doc), opt)
+	if err != nil {
+		t.Fatalf("Import: %v", err)
+	}
+	return strokes
+}
+
+// black1 is a shape that is only filled, outlined with the 1 pixel pen.
+func black1(pts ...[2]int) *codec.Stroke { return &codec.Stroke{Width: 1, Pts: pts} }
+
+func TestImportShapes(t *testing.T) {
+	square := black1([2]int{10, 10}, [2]int{50, 10}, [2]int{50, 40}, [2]int{10, 40}, [2]int{10, 10})
+	tests := []struct {
+		name string
+		body string
+		want []*codec.Stroke
+	}{
+		{"absolute path", `<path d="M10 10 L50 10 V40 H10 Z"/>`, []*codec.Stroke{square}},
+		{"relative path", `<path d="m10 10 l40 0 v30 h-40 z"/>`, []*codec.Stroke{square}},
+		{"implicit lineto", `<path d="M10 10 50 10 50 40 10 40z"/>`, []*codec.Stroke{square}},
+		{"relative implicit lineto", `<path d="m10 10 40 0 0 30 -40 0z"/>`, []*codec.Stroke{square}},
+		{"packed numbers", `<path d="M1.5.5L-3-4e0"/>`, []*codec.Stroke{black1([2]int{2, 1}, [2]int{-3, -4})}},
+		{"subpaths", `<path d="M0 0 L10 0 M20 20 l10 0 M5 5"/>`, []*codec.Stroke{
+			black1([2]int{0, 0}, [2]int{10, 0}), black1([2]int{20, 20}, [2]int{30, 20}),
+		}},
+		{"drawing on after close", `<path d="M10 10 h10 v10 z l-5 5"/>`, []*codec.Stroke{
+			black1([2]int{10, 10}, [2]int{20, 10}, [2]int{20, 20}, [2]int{10, 10}),
+			black1([2]int{10, 10}, [2]int{5, 15}),
+		}},
+		{"malformed command", `<path d="M0 0 L10 0 L20 X30 30"/>`, []*codec.Stroke{black1([2]int{0, 0}, [2]int{10, 0})}},
+		{"rect", `<rect x="10" y="10" width="40" height="30"/>`, []*codec.Stroke{square}},
+		{"line", `<line x1="1" y1="2" x2="3" y2="4" stroke="red" stroke-width="5"/>`,
+			[]*codec.Stroke{{R: 255, Width: 5, Pts: [][2]int{{1, 2}, {3, 4}}}}},
+		{"polyline", `<polyline points="0,0 10,0 10,10"/>`, []*codec.Stroke{black1([2]int{0, 0}, [2]int{10, 0}, [2]int{10, 10})}},
+		{"polygon", `<polygon points="0 0,10 0,10 10"/>`,
+			[]*codec.Stroke{black1([2]int{0, 0}, [2]int{10, 0}, [2]int{10, 10}, [2]int{0, 0})}},
+		{"not painted", `<line x2="10" stroke="none" fill="none"/><rect width="0" height="5"/><circle r="-1"/>`, nil},
+		{"not rendered", `<g display="none"><line x2="10" stroke="red"/></g><line x2="10" stroke="red" opacity="0"/>` +
+			`<defs><line x2="10" stroke="red"/></defs><text>hi</text>`, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := importBody(t, tt.body, Options{}); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("strokes = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// checkCurve checks that a flattened curve runs from first to last, that every
+// point lies on it within tol plus the rounding to whole pixels, and that no
+// segment is longer than maxSeg.
+func checkCurve(t *testing.T, s *codec.Stroke, first, last [2]int, dist func(x, y float64) float64, tol, maxSeg float64) {
+	t.Helper()
+	if n := len(s.Pts); n < 3 || s.Pts[0] != first || s.Pts[n-1] != last {
+		t.Fatalf("curve = %v, want more than a line from %v to %v", s.Pts, first, last)
+	}
+	for i, p := range s.Pts {
+		if d := dist(float64(p[0]), float64(p[1])); d > tol+math.Sqrt2/2 {
+			t.Errorf("point %d %v is %.2f off the curve", i, p, d)
+		}
+		if i > 0 {
+			q := s.Pts[i-1]
+			if l := math.Hypot(float64(p[0]-q[0]), float64(p[1]-q[1])); l > maxSeg {
+				t.Errorf("segment %d is %.1f long", i, l)
+			}
+		}
+	}
+}
+
+func TestImportCurves(t *testing.T) {
+	fromCircle := func(cx, cy, r float64) func(x, y float64) float64 {
+		return func(x, y float64) float64 { return math.Abs(math.Hypot(x-cx, y-cy) - r) }
+	}
+
+	t.Run("circle", func(t *testing.T) {
+		s := importBody(t, `<circle cx="100" cy="100" r="80"/>`, Options{})
+		checkCurve(t, s[0], [2]int{180, 100}, [2]int{180, 100}, fromCircle(100, 100, 80), DefaultTolerance, 15)
+	})
+	t.Run("arc", func(t *testing.T) {
+		s := importBody(t, `<path d="M20 100 A80 80 0 0 1 180 100"/>`, Options{})
+		checkCurve(t, s[0], [2]int{20, 100}, [2]int{180, 100}, fromCircle(100, 100, 80), DefaultTolerance, 15)
+		for _, p := range s[0].Pts {
+			if p[1] > 100 {
+				t.Fatalf("point %v is below the chord; sweep 1 goes through the top", p)
+			}
+		}
+	})
+	t.Run("relative arc with radii scaled up", func(t *testing.T) {
+		// Radii too small for the endpoints grow until they fit: a half circle.
+		s := importBody(t, `<path d="M20 100 a1 1 0 0 0 160 0"/>`, Options{})
+		checkCurve(t, s[0], [2]int{20, 100}, [2]int{180, 100}, fromCircle(100, 100, 80), DefaultTolerance, 15)
+		if s[0].Pts[1][1] < 100 {
+			t.Fatalf("point %v is above the chord; sweep 0 goes through the bottom", s[0].Pts[1])
+		}
+	})
+	t.Run("rotated ellipse arc", func(t *testing.T) {
+		s := importBody(t, `<path d="M100 20 A80 40 90 0 1 100 180"/>`, Options{})
+		ellipse := func(x, y float64) float64 {
+			// Rotated by 90°, the long axis is vertical.
+			return math.Abs(math.Hypot((x-100)*2, y-100) - 80)
+		}
+		checkCurve(t, s[0], [2]int{100, 20}, [2]int{100, 180}, ellipse, 2*DefaultTolerance, 15)
+	})
+	t.Run("zero radius arc", func(t *testing.T) {
+		s := importBody(t, `<path d="M0 0 A0 5 0 0 1 10 0"/>`, Options{})
+		if want := [][2]int{{0, 0}, {10, 0}}; !reflect.DeepEqual(s[0].Pts, want) {
+			t.Errorf("points = %v, want %v", s[0].Pts, want)
+		}
+	})
+	t.Run("rounded rect", func(t *testing.T) {
+		s := importBody(t, `<rect x="10" y="10" width="100" height="60" rx="20"/>`, Options{})
+		pts := s[0].Pts
+		if pts[0] != [2]int{30, 10} || pts[len(pts)-1] != [2]int{30, 10} {
+			t.Errorf("outline runs %v to %v, want (30, 10) to (30, 10)", pts[0], pts[len(pts)-1])
+		}
+		for _, p := range pts {
+			if p[0] < 10 || p[0] > 110 || p[1] < 10 || p[1] > 70 || (p[0] < 16 && p[1] < 16) {
+				t.Errorf("point %v is outside the rounded rectangle", p)
+			}
+		}
+	})
+	t.Run("cubic", func(t *testing.T) {
+		// A cubic with control points on the circle's tangents approximates
+		// a quarter circle to within 0.03% of its radius.
+		const k = 0.5522847498 * 80
+		s := importBody(t, fmt.Sprintf(`<path d="M180 100 C180 %g %g 20 100 20"/>`, 100-k, 100+k), Options{})
+		checkCurve(t, s[0], [2]int{180, 100}, [2]int{100, 20}, fromCircle(100, 100, 80), DefaultTolerance+0.03, 15)
+	})
+	t.Run("smooth and quadratic", func(t *testing.T) {
+		s := importBody(t, `<path d="M0 100 Q50 0 100 100 T200 100 M0 150 C0 100 50 100 50 150 S100 200 100 150"/>`, Options{})
+		if len(s) != 2 {
+			t.Fatalf("%d strokes, want 2", len(s))
+		}
+		// T and S reflect the previous control point, so the second curve of
+		// each bulges the other way.
+		for i, mid := range [][2][2]int{{{50, 50}, {150, 150}}, {{25, 113}, {75, 187}}} {
+			if !hasPointNear(s[i].Pts, mid[0]) || !hasPointNear(s[i].Pts, mid[1]) {
+				t.Errorf("curve %d = %v, want it through %v and %v", i, s[i].Pts, mid[0], mid[1])
+			}
+		}
+	})
+}
+
+// hasPointNear reports whether a point of pts lies within 3 pixels of p.
+func hasPointNear(pts [][2]int, p [2]int) bool {
+	for _, q := range pts {
+		if math.Hypot(float64(q[0]-p[0]), float64(q[1]-p[1])) <= 3 {
+			return true
+		}
+	}
+	return false
+}
+
+func TestImportTransforms(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+		opt  Options
+		want []*codec.Stroke
+	}{
+		{"nested groups", `<svg><g transform="translate(10,20)"><g transform="scale(2)">` +
+			`<line x1="1" y1="1" x2="5" y2="1" stroke="#000"/></g></g></svg>`, Options{},
+			[]*codec.Stroke{{Width: 2, Pts: [][2]int{{12, 22}, {20, 22}}}}},
+		{"transform list order", `<svg><line x2="10" stroke="#000" transform="translate(10) scale(2 3)"/></svg>`, Options{},
+			[]*codec.Stroke{{Width: 2, Pts: [][2]int{{10, 0}, {30, 0}}}}},
+		{"rotate about a point", `<svg><line x1="10" y1="10" x2="20" y2="10" stroke="#000" transform="rotate(90 10 10)"/></svg>`, Options{},
+			[]*codec.Stroke{{Width: 1, Pts: [][2]int{{10, 10}, {10, 20}}}}},
+		{"matrix", `<svg><line x2="10" y2="10" stroke="#000" transform="matrix(1 0 0 -1 5 50)"/></svg>`, Options{},
+			[]*codec.Stroke{{Width: 1, Pts: [][2]int{{5, 50}, {15, 40}}}}},
+		{"malformed entry ends the list", `<svg><line x2="10" stroke="#000" transform="translate(5) bogus(1) scale(9)"/></svg>`, Options{},
+			[]*codec.Stroke{{Width: 1, Pts: [][2]int{{5, 0}, {15, 0}}}}},
+		{"viewBox", `<svg width="100" height="100" viewBox="10 10 50 50"><line x1="10" y1="10" x2="60" y2="60" stroke="#000"/></svg>`, Options{},
+			[]*codec.Stroke{{Width: 2, Pts: [][2]int{{0, 0}, {100, 100}}}}},
+		{"viewBox meet", `<svg width="200" height="100" viewBox="0 0 50 50"><line x2="50" y2="50" stroke="#000"/></svg>`, Options{},
+			[]*codec.Stroke{{Width: 2, Pts: [][2]int{{50, 0}, {150, 100}}}}},
+		{"viewBox none", `<svg width="200" height="100" viewBox="0 0 50 50" preserveAspectRatio="none"><line x2="50" y2="50" stroke="#000"/></svg>`, Options{},
+			[]*codec.Stroke{{Width: 3, Pts: [][2]int{{0, 0}, {200, 100}}}}},
+		{"nested svg", `<svg><g transform="translate(100 0)"><svg x="10" y="20" width="20" height="20" viewBox="0 0 10 10">` +
+			`<line x2="10" stroke="#000"/></svg></g></svg>`, Options{},
+			[]*codec.Stroke{{Width: 2, Pts: [][2]int{{110, 20}, {130, 20}}}}},
+		{"fit to canvas", `<svg width="800" height="600"><line x2="800" y2="600" stroke="#000" stroke-width="4"/></svg>`,
+			Options{Width: 400, Height: 400}, []*codec.Stroke{{Width: 2, Pts: [][2]int{{0, 0}, {400, 300}}}}},
+		{"small documents keep their size", `<svg width="100mm" height="10mm"><line x2="1in" stroke="#000"/></svg>`,
+			Options{Width: 640, Height: 480}, []*codec.Stroke{{Width: 1, Pts: [][2]int{{0, 0}, {96, 0}}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Import(strings.NewReader(tt.doc), tt.opt)
+			if err != nil {
+				t.Fatalf("Import: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("strokes = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestImportColors(t *testing.T) {
+	tests := []struct {
+		name  string
+		attrs string
+		want  codec.Stroke
+	}{
+		{"stroke attribute", `stroke="#1a2B3c" stroke-width="3"`, codec.Stroke{R: 0x1a, G: 0x2b, B: 0x3c, Width: 3}},
+		{"style wins", `stroke="red" style="stroke: #00ff00 ; stroke-width:4px"`, codec.Stroke{G: 255, Width: 4}},
+		{"important", `style="stroke:blue !important;fill:none"`, codec.Stroke{B: 255, Width: 2}},
+		{"short hex", `style="stroke:#abc"`, codec.Stroke{R: 0xaa, G: 0xbb, B: 0xcc, Width: 2}},
+		{"hex with alpha", `stroke="#102030ff"`, codec.Stroke{R: 0x10, G: 0x20, B: 0x30, Width: 2}},
+		{"rgb", `style="stroke: rgb(1, 2, 3)"`, codec.Stroke{R: 1, G: 2, B: 3, Width: 2}},
+		{"rgb percentages", `stroke="rgba(100%,50%,0%,0.5)"`, codec.Stroke{R: 255, G: 128, Width: 2}},
+		{"named", `style="stroke:Orange"`, codec.Stroke{R: 255, G: 165, Width: 2}},
+		{"currentColor", `style="color:navy;stroke:currentColor"`, codec.Stroke{B: 128, Width: 2}},
+		{"inherited", `stroke="inherit"`, codec.Stroke{R: 128, Width: 2}},
+		{"gradient fallback", `stroke="url(#g) teal"`, codec.Stroke{G: 128, B: 128, Width: 2}},
+		{"unknown keeps inherited", `stroke="chartreuse-ish"`, codec.Stroke{R: 128, Width: 2}},
+		{"fill only", `style="stroke:none;fill:lime"`, codec.Stroke{G: 255, Width: 1}},
+		{"transparent stroke", `style="stroke-opacity:0;fill:#ff0000"`, codec.Stroke{R: 255, Width: 1}},
+		{"zero width", `style="stroke-width:0;fill:silver"`, codec.Stroke{R: 192, G: 192, B: 192, Width: 1}},
+		{"wide", `stroke-width="1000"`, codec.Stroke{R: 128, Width: 255}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := importBody(t, `<g stroke="maroon" stroke-width="2"><line x2="10" `+tt.attrs+`/></g>`, Options{})
+			want := tt.want
+			want.Pts = [][2]int{{0, 0}, {10, 0}}
+			if len(got) != 1 {
+				t.Fatalf("%d strokes, want 1", len(got))
+			}
+			if !reflect.DeepEqual(*got[0], want) {
+				t.Errorf("stroke = %v, want %v", *got[0], want)
+			}
+		})
+	}
+}
+
+func TestImportLimits(t *testing.T) {
+	t.Run("flattening", func(t *testing.T) {
+		// A huge circle at a tiny tolerance still takes at most maxSegments
+		// per arc.
+		doc := `<svg><circle r="1e9"/></svg>`
+		s, err := Import(strings.NewReader(doc), Options{Tolerance: 1e-9})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := len(s[0].Pts); n > 2*maxSegments+1 {
+			t.Errorf("circle flattened into %d points", n)
+		}
+	})
+
+	limits := codec.Limits{MaxCmds: 3, MaxPoints: 10, MaxStrokePoints: 3}
+	st := defaultStyle
+	pts := func(n int) []pt {
+		var p []pt
+		for i := range n {
+			p = append(p, pt{float64(i), 0})
+		}
+		return p
+	}
+	t.Run("long strokes are split", func(t *testing.T) {
+		imp := importer{tol: DefaultTolerance, limits: limits}
+		if err := imp.add([][]pt{pts(5)}, &st); err != nil {
+			t.Fatal(err)
+		}
+		want := []*codec.Stroke{black1([2]int{0, 0}, [2]int{1, 0}, [2]int{2, 0}), black1([2]int{2, 0}, [2]int{3, 0}, [2]int{4, 0})}
+		if !reflect.DeepEqual(imp.strokes, want) {
+			t.Errorf("strokes = %v, want %v", imp.strokes, want)
+		}
+	})
+	tests := []struct {
+		name     string
+		subpaths [][]pt
+		want     error
+	}{
+		{"points", [][]pt{pts(3), pts(3), pts(3), pts(2)}, &codec.LimitError{What: "points", Limit: 10}},
+		{"commands", [][]pt{pts(2), pts(2), pts(2), pts(2)}, &codec.LimitError{What: "commands", Limit: 3}},
+		{"within", [][]pt{pts(3), pts(2), pts(3)}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			imp := importer{tol: DefaultTolerance, limits: limits}
+			if err := imp.add(tt.subpaths, &st); !reflect.DeepEqual(err, tt.want) {
+				t.Errorf("add: %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestImportErrors(t *testing.T) {
+	var syntax *xml.SyntaxError
+	tests := []struct {
+		name string
+		doc  string
+		want func(error) bool
+	}{
+		{"empty", ``, func(err error) bool { return err == ErrNotSVG }},
+		{"other document", `<html><svg/></html>`, func(err error) bool { return err == ErrNotSVG }},
+		{"unclosed", `<svg><path d="M0 0 L5 5"/>`, func(err error) bool { return errors.As(err, &syntax) }},
+		{"mismatched", `<svg><g></svg>`, func(err error) bool { return errors.As(err, &syntax) }},
+		{"bad attribute", `<svg><path d=M0/></svg>`, func(err error) bool { return errors.As(err, &syntax) }},
+		{"truncated", `<svg><line x1="0`, func(err error) bool { return errors.As(err, &syntax) }},
+		{"bad skipped element", `<svg><defs><g></defs></svg>`, func(err error) bool { return errors.As(err, &syntax) }},
+		{"text", `just text`, func(err error) bool { return err == ErrNotSVG }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Import(strings.NewReader(tt.doc), Options{})
+			if !tt.want(err) {
+				t.Errorf("Import: %v", err)
+			}
+		})
+	}
+}
+
+// Malformed geometry is drawn as far as it makes sense, never failing or
+// panicking.
+func TestImportMalformedGeometry(t *testing.T) {
+	for _, body := range []string{
+		`<path d="M"/>`, `<path d="A"/>`, `<path d="Z L5 5"/>`, `<path d="M0 0 A5"/>`, `<path d="M0 0 C1 1"/>`,
+		`<path d="M0 0 A5 5 0 2 1 9 9"/>`, `<path d="M1e999 0 L0 0"/>`, `<path d="M0 0 L1e300 1e300"/>`,
+		`<path d="10 10 L5 5"/>`, `<polyline points="1 2 3"/>`, `<polygon points=""/>`,
+		`<line x2="10" stroke="#000" transform="scale(0)"/>`, `<line x2="10" stroke="#000" transform="matrix(1e308 0 0 1e308 1e308 0)"/>`,
+		`<line x2="10" stroke="#000" transform="rotate(45"/>`, `<circle r="NaN"/>`, `<rect width="10" height="10" rx="-5"/>`,
+		`<svg viewBox="0 0 0 0"><line x2="10" stroke="#000"/></svg>`, `<line x2="10" stroke="#000" stroke-width="-1"/>`,
+		`<line x2="10" style=";;:;stroke:;fill:rgb(1,2)"/>`, `<path d="M0 0 Q" stroke="#000"/>`,
+	} {
+		importBody(t, body, Options{Width: 640, Height: 480})
+	}
+}